@@ -0,0 +1,305 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveSeparator joins an archive path and a member name into a wordlist path,
+// e.g. lists.tar.gz!/rockyou.txt
+const archiveSeparator = "!/"
+
+const (
+	archiveZip = "zip"
+	archiveTar = "tar"
+)
+
+var zipMagic = []byte{'P', 'K', 0x03, 0x04}
+
+// archiveKind detects zip and (optionally compressed) tar files by their magic bytes
+func archiveKind(filename string) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	magic := make([]byte, len(zipMagic))
+	_, err = io.ReadFull(file, magic)
+	file.Close()
+	if err == nil && bytes.Equal(magic, zipMagic) {
+		return archiveZip
+	}
+
+	in, err := openInput(filename)
+	if err != nil {
+		return ""
+	}
+	defer in.Close()
+	header := make([]byte, 262)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return ""
+	}
+	// POSIX and GNU tar headers both carry "ustar" at offset 257
+	if bytes.Equal(header[257:262], []byte("ustar")) {
+		return archiveTar
+	}
+	return ""
+}
+
+// matchesArchiveGlobs reports whether a member is selected by the --archive-glob patterns,
+// matched against both the full member name and its base name
+func matchesArchiveGlobs(member string, globs []string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, member); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(member)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listArchiveMembers returns a wordlist path for every regular file in the archive that
+// matches the globs
+func listArchiveMembers(filename, kind string, globs []string) ([]string, error) {
+	var members []string
+	switch kind {
+	case archiveZip:
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() || !matchesArchiveGlobs(f.Name, globs) {
+				continue
+			}
+			members = append(members, filename+archiveSeparator+f.Name)
+		}
+	case archiveTar:
+		index, err := indexTar(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range index.names {
+			if matchesArchiveGlobs(name, globs) {
+				members = append(members, filename+archiveSeparator+name)
+			}
+		}
+	}
+	return members, nil
+}
+
+// splitArchivePath splits a wordlist path made by listArchiveMembers, ok is false for
+// plain file paths
+func splitArchivePath(filename string) (archive, member string, ok bool) {
+	idx := strings.Index(filename, archiveSeparator)
+	if idx < 0 {
+		return "", "", false
+	}
+	archive = filename[:idx]
+	if info, err := os.Stat(archive); err != nil || !info.Mode().IsRegular() {
+		return "", "", false
+	}
+	return archive, filename[idx+len(archiveSeparator):], true
+}
+
+// openArchiveMember streams one member out of a zip or tar archive, without extracting it
+func openArchiveMember(archive, member string) (io.ReadCloser, error) {
+	switch archiveKind(archive) {
+	case archiveZip:
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.Name != member {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return newInput(rc, &inputFile{closers: []io.Closer{zr, rc}}, archive+archiveSeparator+member)
+		}
+		zr.Close()
+	case archiveTar:
+		index, err := indexTar(archive)
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := index.members[member]; ok {
+			return index.open(archive, member, m)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a zip or tar archive", archive)
+	}
+	return nil, fmt.Errorf("%s not found in %s", member, archive)
+}
+
+// tarCacheLimit is how many bytes of compressed tar archive members are kept in memory
+// altogether. Members past it are read by decompressing the archive up to them again.
+const tarCacheLimit = 256 << 20
+
+// tarMember is where the contents of a regular file are in a tar archive
+type tarMember struct {
+	offset int64  // in the uncompressed archive, -1 when the contents are not stored as is
+	size   int64  // of the contents
+	data   []byte // contents of a member of a compressed archive, nil when not kept
+}
+
+// tarIndex is the regular files of a tar archive, made in one pass so that opening a member
+// does not read the archive up to it. Members of an uncompressed archive are read at their
+// offset, members of a compressed one from memory.
+type tarIndex struct {
+	modTime    time.Time
+	size       int64
+	compressed bool
+	names      []string // in archive order, as often as they are in it
+	members    map[string]*tarMember
+	cached     int64 // bytes of member data kept
+}
+
+var (
+	tarIndexesMu sync.Mutex
+	tarIndexes   = make(map[string]*tarIndex)
+	tarCached    int64 // bytes of member data kept by all indexes
+)
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// indexTar returns the index of a tar archive, made again when the archive changed since
+func indexTar(archive string) (*tarIndex, error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	tarIndexesMu.Lock()
+	defer tarIndexesMu.Unlock()
+	if index, ok := tarIndexes[archive]; ok {
+		if index.modTime.Equal(info.ModTime()) && index.size == info.Size() {
+			return index, nil
+		}
+		tarCached -= index.cached
+		delete(tarIndexes, archive)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	in, err := newInput(file, file, archive)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	index := &tarIndex{
+		modTime:    info.ModTime(),
+		size:       info.Size(),
+		compressed: in.compressed(),
+		members:    make(map[string]*tarMember),
+	}
+	counter := &countingReader{Reader: in}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tarCached -= index.cached
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		index.names = append(index.names, hdr.Name)
+		if _, ok := index.members[hdr.Name]; ok {
+			// opening a name opens its first member
+			continue
+		}
+		m := &tarMember{offset: counter.n, size: hdr.Size}
+		if isSparseTarMember(hdr) {
+			m.offset = -1
+		}
+		if index.compressed && tarCached+hdr.Size <= tarCacheLimit {
+			if m.data, err = io.ReadAll(tr); err != nil {
+				tarCached -= index.cached
+				return nil, err
+			}
+			index.cached += hdr.Size
+			tarCached += hdr.Size
+		}
+		index.members[hdr.Name] = m
+	}
+	tarIndexes[archive] = index
+	return index, nil
+}
+
+// isSparseTarMember tells if the contents of a member are stored as a sparse map and data
+// rather than as they are
+func isSparseTarMember(hdr *tar.Header) bool {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// open streams member m of the archive
+func (index *tarIndex) open(archive, member string, m *tarMember) (io.ReadCloser, error) {
+	name := archive + archiveSeparator + member
+	if m.data != nil {
+		r := bytes.NewReader(m.data)
+		return newInput(r, io.NopCloser(r), name)
+	}
+	if !index.compressed && m.offset >= 0 {
+		file, err := os.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		return newInput(io.NewSectionReader(file, m.offset, m.size), file, name)
+	}
+	// not kept, read the archive up to the member
+	in, err := openInput(archive)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			in.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("%s not found in %s", member, archive)
+			}
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && hdr.Name == member {
+			return newInput(tr, in, name)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// tarMembers is an archive with a directory, a gzip compressed member and a name twice
+var tarMembers = []struct{ name, content string }{
+	{"lists/", ""},
+	{"lists/a.txt", "alpha\nbravo\n"},
+	{"lists/b.txt.gz", "charlie\n"},
+	{"c.txt", "delta\necho\nfoxtrot\n"},
+	{"lists/a.txt", "golf\n"},
+}
+
+// writeTar writes tarMembers in an archive, gzip compressed when compress is set
+func writeTar(t *testing.T, path string, compress bool) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range tarMembers {
+		hdr := &tar.Header{Name: m.name, Mode: 0644, Typeflag: tar.TypeReg}
+		content := []byte(m.content)
+		if filepath.Ext(m.name) == ".gz" {
+			content = gzipped(t, content)
+		}
+		if m.content == "" {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		hdr.Size = int64(len(content))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if compress {
+		data = gzipped(t, data)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, r io.ReadCloser) string {
+	t.Helper()
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTarMembers(t *testing.T) {
+	for name, compress := range map[string]bool{"plain": false, "gzip": true} {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "lists.tar")
+			writeTar(t, archive, compress)
+
+			members, err := listArchiveMembers(archive, archiveKind(archive), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"lists/a.txt", "lists/b.txt.gz", "c.txt", "lists/a.txt"}
+			for i := range want {
+				want[i] = archive + archiveSeparator + want[i]
+			}
+			if !slices.Equal(members, want) {
+				t.Fatalf("members %q, want %q", members, want)
+			}
+			index := tarIndexes[archive]
+			if index == nil || index.compressed != compress {
+				t.Fatalf("index %+v of a compressed %v archive", index, compress)
+			}
+
+			// every member opens more than once, a name opens its first member
+			contents := map[string]string{"lists/a.txt": "alpha\nbravo\n", "lists/b.txt.gz": "charlie\n", "c.txt": "delta\necho\nfoxtrot\n"}
+			for range 2 {
+				for member, content := range contents {
+					in, err := openInput(archive + archiveSeparator + member)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := readAll(t, in); got != content {
+						t.Errorf("%s holds %q, want %q", member, got, content)
+					}
+				}
+			}
+			if tarIndexes[archive] != index {
+				t.Error("the archive was indexed again")
+			}
+			if _, err := openInput(archive + archiveSeparator + "missing.txt"); err == nil {
+				t.Error("opened a member that is not in the archive")
+			}
+
+			if !compress {
+				return
+			}
+			// members not kept in memory are read by decompressing the archive up to them
+			for member, content := range contents {
+				m := *index.members[member]
+				m.data = nil
+				in, err := index.open(archive, member, &m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := readAll(t, in); got != content {
+					t.Errorf("%s holds %q when not kept, want %q", member, got, content)
+				}
+			}
+		})
+	}
+}
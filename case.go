@@ -0,0 +1,80 @@
+package main
+
+import "unicode"
+
+// case permutation modes for target words
+const (
+	caseNone  = "none"
+	caseAll   = "all"
+	caseFirst = "first"
+	caseLast  = "last"
+	caseEnds  = "ends"
+)
+
+// toggleCase swaps a lower case letter to upper case and the other way around
+func toggleCase(r rune) rune {
+	if unicode.IsUpper(r) {
+		return unicode.ToLower(r)
+	}
+	return unicode.ToUpper(r)
+}
+
+// expandCase returns the case variants of word, without the word itself. In caseAll mode every
+// combination of toggled letters is made, with at most maxToggles toggled letters when it is
+// above 0. The other modes only toggle the first and/or last letter.
+func expandCase(word, mode string, maxToggles int) []string {
+	runes := []rune(word)
+	var letters []int
+	for i, r := range runes {
+		if toggleCase(r) != r {
+			letters = append(letters, i)
+		}
+	}
+	if len(letters) == 0 {
+		return nil
+	}
+
+	first, last := letters[0], letters[len(letters)-1]
+	switch mode {
+	case caseFirst:
+		letters = []int{first}
+	case caseLast:
+		letters = []int{last}
+	case caseEnds:
+		letters = removeDuplicateInts([]int{first, last})
+	case caseAll:
+	default:
+		return nil
+	}
+
+	var variants []string
+	var walk func(i, toggles int)
+	walk = func(i, toggles int) {
+		if i == len(letters) {
+			if toggles > 0 {
+				variants = append(variants, string(runes))
+			}
+			return
+		}
+		walk(i+1, toggles)
+		if maxToggles > 0 && toggles >= maxToggles {
+			return
+		}
+		pos := letters[i]
+		runes[pos] = toggleCase(runes[pos])
+		walk(i+1, toggles+1)
+		runes[pos] = toggleCase(runes[pos])
+	}
+	walk(0, 0)
+	return variants
+}
+
+func removeDuplicateInts(values []int) []int {
+	var result []int
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
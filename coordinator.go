@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CoordinateCLI holds the arguments of the coordinate command
+type CoordinateCLI struct {
+	Listen    string        `optional:"" help:"Address to listen on, 0.0.0.0:8090 for workers on the network" default:"127.0.0.1:8090"`
+	ChunkSize uint64        `optional:"" help:"Candidates per chunk handed to a worker" default:"10000000"`
+	Timeout   time.Duration `optional:"" help:"Hand out the chunks of a worker again when it was not heard from for this long" default:"2m"`
+	State     string        `optional:"" help:"File keeping the finished index ranges, to continue the job after a restart" default:""`
+	Debug     bool          `optional:"" help:"Show Debug Messages" default:"false"`
+	Job       []string      `arg:"" passthrough:"" help:"Target file, wordlists and options of the job after --, as when generating"`
+}
+
+// indexRange is the keyspace indexes from Start up to End
+type indexRange struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// rangeSet is a sorted list of ranges that neither overlap nor touch
+type rangeSet []indexRange
+
+// add merges r into the set
+func (s *rangeSet) add(r indexRange) {
+	if r.Start >= r.End {
+		return
+	}
+	var merged rangeSet
+	for _, other := range *s {
+		if other.End < r.Start || other.Start > r.End {
+			merged = append(merged, other)
+			continue
+		}
+		r = indexRange{min(r.Start, other.Start), max(r.End, other.End)}
+	}
+	merged = append(merged, r)
+	slices.SortFunc(merged, func(a, b indexRange) int { return compareUint64(a.Start, b.Start) })
+	*s = merged
+}
+
+// take cuts up to size indexes off the start of the first range
+func (s *rangeSet) take(size uint64) (indexRange, bool) {
+	if len(*s) == 0 {
+		return indexRange{}, false
+	}
+	first := &(*s)[0]
+	r := indexRange{first.Start, first.Start + min(size, first.End-first.Start)}
+	first.Start = r.End
+	if first.Start == first.End {
+		*s = (*s)[1:]
+	}
+	return r, true
+}
+
+// size is the amount of indexes in the set
+func (s rangeSet) size() uint64 {
+	var total uint64
+	for _, r := range s {
+		total += r.End - r.Start
+	}
+	return total
+}
+
+func compareUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// coordinatorState is the content of the --state file
+type coordinatorState struct {
+	Job      []string `json:"job"`
+	Keyspace uint64   `json:"keyspace"`
+	Finished rangeSet `json:"finished"`
+}
+
+// assignment is a chunk handed to a worker
+type assignment struct {
+	ID     int        `json:"id"`
+	Range  indexRange `json:"range"`
+	Worker string     `json:"worker"`
+}
+
+// workerInfo is a registered worker
+type workerInfo struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// coordinator hands out chunks of the keyspace of one job to workers and keeps track of the
+// finished ones. Chunks of workers that stop sending heartbeats are handed out again.
+type coordinator struct {
+	job       []string
+	keyspace  uint64
+	chunkSize uint64
+	timeout   time.Duration
+	statePath string
+	debug     bool
+
+	mu         sync.Mutex
+	unassigned rangeSet
+	finished   rangeSet
+	assigned   map[int]*assignment
+	nextID     int
+	workers    map[string]*workerInfo
+}
+
+// runCoordinate runs the coordinator until it fails
+func runCoordinate(cmd CoordinateCLI) {
+	c, err := newCoordinator(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Coordinating a keyspace of %d, %d finished, on %s", c.keyspace, c.finished.size(), cmd.Listen)
+	go c.expireWorkers()
+	log.Fatal(http.ListenAndServe(cmd.Listen, c.handler()))
+}
+
+// newCoordinator prepares the job of cmd and what is left of it after the --state
+func newCoordinator(cmd CoordinateCLI) (*coordinator, error) {
+	if cmd.ChunkSize == 0 {
+		return nil, errors.New("ChunkSize must be greater than 0")
+	}
+	// workers send a heartbeat every quarter of the timeout, but not more than once a second
+	if cmd.Timeout < 4*time.Second {
+		return nil, errors.New("Timeout must be at least 4s")
+	}
+	// the passthrough keeps the -- in front of the job
+	if len(cmd.Job) > 0 && cmd.Job[0] == "--" {
+		cmd.Job = cmd.Job[1:]
+	}
+	cli, err := parseJobArgs(cmd.Job)
+	if err != nil {
+		return nil, err
+	}
+	cli.Debug = cmd.Debug
+	g, err := prepareGeneration(cli)
+	if err != nil {
+		return nil, err
+	}
+	keyspace, err := g.keyspace()
+	if err != nil {
+		return nil, err
+	}
+	c := &coordinator{
+		job:       cmd.Job,
+		keyspace:  keyspace,
+		chunkSize: cmd.ChunkSize,
+		timeout:   cmd.Timeout,
+		statePath: cmd.State,
+		debug:     cmd.Debug,
+		finished:  rangeSet{},
+		assigned:  make(map[int]*assignment),
+		workers:   make(map[string]*workerInfo),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	// what is not finished yet is left to hand out
+	var start uint64
+	for _, r := range append(c.finished, indexRange{c.keyspace, c.keyspace}) {
+		c.unassigned.add(indexRange{start, r.Start})
+		start = r.End
+	}
+	return c, nil
+}
+
+// handler routes the endpoints of the coordinator
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /workers", c.register)
+	mux.HandleFunc("POST /workers/{id}/heartbeat", c.heartbeat)
+	mux.HandleFunc("POST /workers/{id}/chunk", c.chunk)
+	mux.HandleFunc("POST /chunks/{id}/finish", c.finish)
+	mux.HandleFunc("GET /status", c.status)
+	return mux
+}
+
+// load reads the finished ranges of the --state file, if there is one
+func (c *coordinator) load() error {
+	if c.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var state coordinatorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("reading state %s: %w", c.statePath, err)
+	}
+	if !slices.Equal(state.Job, c.job) || state.Keyspace != c.keyspace {
+		return fmt.Errorf("state %s is of another job: %q", c.statePath, state.Job)
+	}
+	c.finished = state.Finished
+	return nil
+}
+
+// save writes the --state file, the caller holds the lock
+func (c *coordinator) save() {
+	if c.statePath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(coordinatorState{Job: c.job, Keyspace: c.keyspace, Finished: c.finished}, "", "  ")
+	if err == nil {
+		temp := c.statePath + ".tmp"
+		if err = os.WriteFile(temp, append(data, '\n'), 0644); err == nil {
+			err = os.Rename(temp, c.statePath)
+		}
+	}
+	if err != nil {
+		log.Printf("Saving state: %v", err)
+	}
+}
+
+// expireWorkers checks for workers that timed out until the coordinator exits
+func (c *coordinator) expireWorkers() {
+	for now := range time.Tick(max(c.timeout/4, time.Second)) {
+		c.expire(now)
+	}
+}
+
+// expire drops the workers that were not heard from within the timeout before now and hands out
+// their chunks again
+func (c *coordinator) expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, w := range c.workers {
+		if now.Sub(w.LastSeen) < c.timeout {
+			continue
+		}
+		log.Printf("Worker %s (%s) timed out", w.Name, id)
+		delete(c.workers, id)
+		for chunkID, a := range c.assigned {
+			if a.Worker == id {
+				c.unassigned.add(a.Range)
+				delete(c.assigned, chunkID)
+			}
+		}
+	}
+}
+
+// worker returns the worker of the request and marks it as seen, or writes a 404
+func (c *coordinator) worker(w http.ResponseWriter, r *http.Request) *workerInfo {
+	worker := c.workers[r.PathValue("id")]
+	if worker == nil {
+		http.Error(w, "unknown worker", http.StatusNotFound)
+		return nil
+	}
+	worker.LastSeen = time.Now()
+	return worker
+}
+
+// register handles POST /workers, answering with the job
+func (c *coordinator) register(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	worker := &workerInfo{ID: hex.EncodeToString(id), Name: req.Name, LastSeen: time.Now()}
+	c.mu.Lock()
+	c.workers[worker.ID] = worker
+	c.mu.Unlock()
+	log.Printf("Worker %s (%s) registered", worker.Name, worker.ID)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"id":        worker.ID,
+		"job":       c.job,
+		"keyspace":  c.keyspace,
+		"heartbeat": (c.timeout / 4).Seconds(),
+	})
+}
+
+// heartbeat handles POST /workers/{id}/heartbeat
+func (c *coordinator) heartbeat(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.worker(w, r) != nil {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// chunk handles POST /workers/{id}/chunk. The answer is a chunk, done when the whole keyspace
+// is finished, or a number of seconds to wait when the rest is being worked on.
+func (c *coordinator) chunk(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	worker := c.worker(w, r)
+	if worker == nil {
+		return
+	}
+	next, ok := c.unassigned.take(c.chunkSize)
+	if !ok {
+		if len(c.assigned) == 0 {
+			writeJSON(w, http.StatusOK, map[string]any{"done": true})
+		} else {
+			writeJSON(w, http.StatusOK, map[string]any{"wait": max(c.timeout/8, time.Second).Seconds()})
+		}
+		return
+	}
+	c.nextID++
+	a := &assignment{ID: c.nextID, Range: next, Worker: worker.ID}
+	c.assigned[a.ID] = a
+	if c.debug {
+		log.Printf("Chunk %d (%d-%d) to worker %s", a.ID, next.Start, next.End, worker.Name)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"chunk": a})
+}
+
+// finish handles POST /chunks/{id}/finish. Workers stopping early report the position they got
+// to, the rest of the chunk is handed out again.
+func (c *coordinator) finish(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Worker   string `json:"worker"`
+		Position uint64 `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a := c.assigned[id]
+	if a == nil || a.Worker != req.Worker {
+		http.Error(w, "chunk is not assigned to this worker", http.StatusConflict)
+		return
+	}
+	if worker := c.workers[req.Worker]; worker != nil {
+		worker.LastSeen = time.Now()
+	}
+	position := min(max(req.Position, a.Range.Start), a.Range.End)
+	delete(c.assigned, id)
+	c.finished.add(indexRange{a.Range.Start, position})
+	c.unassigned.add(indexRange{position, a.Range.End})
+	c.save()
+	if c.debug || position < a.Range.End {
+		log.Printf("Chunk %d finished up to %d of %d-%d", id, position, a.Range.Start, a.Range.End)
+	}
+	if c.finished.size() == c.keyspace {
+		log.Printf("Job finished, all %d candidates", c.keyspace)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// status handles GET /status
+func (c *coordinator) status(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assigned := make([]*assignment, 0, len(c.assigned))
+	for _, a := range c.assigned {
+		assigned = append(assigned, a)
+	}
+	slices.SortFunc(assigned, func(a, b *assignment) int { return a.ID - b.ID })
+	workers := make([]*workerInfo, 0, len(c.workers))
+	for _, worker := range c.workers {
+		workers = append(workers, worker)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"job":        c.job,
+		"keyspace":   c.keyspace,
+		"finished":   c.finished,
+		"done":       c.finished.size(),
+		"unassigned": c.unassigned.size(),
+		"assigned":   assigned,
+		"workers":    workers,
+	})
+}
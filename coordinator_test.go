@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRangeSetAdd(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		add  []indexRange
+		want rangeSet
+	}{
+		{"disjoint out of order", []indexRange{{20, 30}, {0, 10}}, rangeSet{{0, 10}, {20, 30}}},
+		{"touching", []indexRange{{0, 10}, {10, 20}}, rangeSet{{0, 20}}},
+		{"touching before", []indexRange{{10, 20}, {0, 10}}, rangeSet{{0, 20}}},
+		{"overlapping", []indexRange{{0, 15}, {10, 20}}, rangeSet{{0, 20}}},
+		{"contained", []indexRange{{0, 20}, {5, 10}}, rangeSet{{0, 20}}},
+		{"containing", []indexRange{{5, 10}, {0, 20}}, rangeSet{{0, 20}}},
+		{"bridging", []indexRange{{0, 10}, {20, 30}, {40, 50}, {5, 45}}, rangeSet{{0, 50}}},
+		{"filling a gap", []indexRange{{0, 10}, {20, 30}, {10, 20}}, rangeSet{{0, 30}}},
+		{"empty", []indexRange{{0, 10}, {15, 15}, {30, 20}}, rangeSet{{0, 10}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var s rangeSet
+			for _, r := range tt.add {
+				s.add(r)
+			}
+			if !slices.Equal(s, tt.want) {
+				t.Errorf("got %v, want %v", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeSetTake(t *testing.T) {
+	s := rangeSet{{0, 25}, {40, 50}}
+	var got []indexRange
+	for {
+		r, ok := s.take(10)
+		if !ok {
+			break
+		}
+		got = append(got, r)
+	}
+	// a chunk does not span the gap between ranges
+	want := []indexRange{{0, 10}, {10, 20}, {20, 25}, {40, 50}}
+	if !slices.Equal(got, want) {
+		t.Errorf("took %v, want %v", got, want)
+	}
+	if len(s) != 0 || s.size() != 0 {
+		t.Errorf("%v left after taking everything", s)
+	}
+}
+
+// newTestCoordinator is a coordinator of a keyspace without a job behind it
+func newTestCoordinator(keyspace, chunkSize uint64) (*coordinator, *httptest.Server) {
+	c := &coordinator{
+		keyspace:  keyspace,
+		chunkSize: chunkSize,
+		timeout:   time.Minute,
+		finished:  rangeSet{},
+		assigned:  make(map[int]*assignment),
+		workers:   make(map[string]*workerInfo),
+	}
+	c.unassigned.add(indexRange{0, keyspace})
+	return c, httptest.NewServer(c.handler())
+}
+
+// chunkAnswer is the answer to POST /workers/{id}/chunk
+type chunkAnswer struct {
+	Chunk *assignment `json:"chunk"`
+	Wait  float64     `json:"wait"`
+	Done  bool        `json:"done"`
+}
+
+func (c *coordinatorClient) nextChunk() (chunkAnswer, error) {
+	var answer chunkAnswer
+	err := c.post("/workers/"+c.workerID()+"/chunk", struct{}{}, &answer)
+	return answer, err
+}
+
+func (c *coordinatorClient) finish(chunk *assignment, position uint64) error {
+	return c.post(fmt.Sprintf("/chunks/%d/finish", chunk.ID), map[string]any{"worker": chunk.Worker, "position": position}, nil)
+}
+
+func TestCoordinatorFinishPartial(t *testing.T) {
+	c, server := newTestCoordinator(100, 30)
+	defer server.Close()
+	client := &coordinatorClient{url: server.URL, name: "w1"}
+	if _, err := client.register(); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.nextChunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Chunk == nil || answer.Chunk.Range != (indexRange{0, 30}) {
+		t.Fatalf("first chunk %+v, want 0-30", answer.Chunk)
+	}
+	// the worker stopped after candidate 10, the rest of its chunk is handed out again first
+	if err := client.finish(answer.Chunk, 10); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(c.finished, rangeSet{{0, 10}}) {
+		t.Errorf("finished %v, want 0-10", c.finished)
+	}
+	answer, err = client.nextChunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rest merged with the indexes after it
+	if answer.Chunk == nil || answer.Chunk.Range != (indexRange{10, 40}) {
+		t.Fatalf("next chunk %+v, want the rest 10-40", answer.Chunk)
+	}
+	// finishing twice is refused
+	if err := client.finish(answer.Chunk, 40); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.finish(answer.Chunk, 40); err == nil {
+		t.Error("a chunk was finished twice")
+	}
+	if !slices.Equal(c.finished, rangeSet{{0, 40}}) {
+		t.Errorf("finished %v, want 0-40", c.finished)
+	}
+}
+
+func TestCoordinatorDeadWorker(t *testing.T) {
+	const keyspace = 1000
+	c, server := newTestCoordinator(keyspace, 64)
+	defer server.Close()
+
+	dead := &coordinatorClient{url: server.URL, name: "dead"}
+	if _, err := dead.register(); err != nil {
+		t.Fatal(err)
+	}
+	lost, err := dead.nextChunk()
+	if err != nil || lost.Chunk == nil {
+		t.Fatalf("chunk of the dead worker: %+v, %v", lost, err)
+	}
+
+	// the live worker does everything else, then waits for the chunk of the dead one
+	live := &coordinatorClient{url: server.URL, name: "live"}
+	if _, err := live.register(); err != nil {
+		t.Fatal(err)
+	}
+	var done []indexRange
+	expired := false
+	for {
+		answer, err := live.nextChunk()
+		if err == errUnknownWorker {
+			// forgotten along with the dead worker, as workers do it registers again
+			if _, err := live.register(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if answer.Done {
+			break
+		}
+		if answer.Chunk == nil {
+			if expired {
+				t.Fatal("still waiting after the dead worker timed out")
+			}
+			c.expire(time.Now().Add(c.timeout))
+			expired = true
+			continue
+		}
+		if err := live.finish(answer.Chunk, answer.Chunk.Range.End); err != nil {
+			t.Fatal(err)
+		}
+		done = append(done, answer.Chunk.Range)
+	}
+	if !expired {
+		t.Error("the job was done without the chunk of the dead worker")
+	}
+
+	// every index was finished exactly once
+	slices.SortFunc(done, func(a, b indexRange) int { return compareUint64(a.Start, b.Start) })
+	var next uint64
+	for _, r := range done {
+		if r.Start != next {
+			t.Fatalf("chunks %v do not cover the keyspace once", done)
+		}
+		next = r.End
+	}
+	if next != keyspace {
+		t.Errorf("chunks end at %d, want %d", next, keyspace)
+	}
+	if !slices.Equal(c.finished, rangeSet{{0, keyspace}}) {
+		t.Errorf("finished %v, want the whole keyspace", c.finished)
+	}
+	if err := dead.finish(lost.Chunk, lost.Chunk.Range.End); err == nil {
+		t.Error("the dead worker finished a chunk that was handed to another")
+	}
+}
+
+func TestNewCoordinatorRefusesShortTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Second, 4*time.Second - 1} {
+		if _, err := newCoordinator(CoordinateCLI{ChunkSize: 10, Timeout: timeout}); err == nil {
+			t.Errorf("timeout %v was accepted", timeout)
+		}
+	}
+}
+
+func TestCoordinatorWorkerProcesses(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"targets.txt":  numbered("word", 6),
+		"wordlist.txt": numbered("", 12),
+	})
+	args := []string{filepath.Join(dir, "targets.txt"), filepath.Join(dir, "wordlist.txt"), "-x", "3"}
+	full := lines(mustTarginator(t, dir, args...))
+
+	c, err := newCoordinator(CoordinateCLI{ChunkSize: 500, Timeout: 4 * time.Second, Job: append([]string{"--"}, args...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c.handler())
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := targinator(dir, "work", server.URL, "--name", fmt.Sprintf("w%d", i), "-o", fmt.Sprintf("out%d.txt", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var written []string
+	for i := 1; i <= 2; i++ {
+		out, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("out%d.txt", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, lines(string(out))...)
+	}
+	slices.Sort(written)
+	slices.Sort(full)
+	if !slices.Equal(written, full) {
+		t.Errorf("workers wrote %d candidates, want the %d of the full output", len(written), len(full))
+	}
+	if !slices.Equal(c.finished, rangeSet{{0, c.keyspace}}) {
+		t.Errorf("finished %v, want the whole keyspace %d", c.finished, c.keyspace)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDateFormats are the forms a date is most often written as in a password,
+// for 1990-05-12: 1990 90 0512 1205 12051990 05121990 May1990 12May
+var defaultDateFormats = []string{"YYYY", "YY", "MMDD", "DDMM", "DDMMYYYY", "MMDDYYYY", "MonYYYY", "DDMon"}
+
+// dateRaw keeps the target as it was written next to its expanded forms
+const dateRaw = "raw"
+
+// date orders for numeric dates such as 05/12/1990
+const (
+	dateOrderDMY = "dmy"
+	dateOrderMDY = "mdy"
+)
+
+// dateLayoutTokens are the placeholders of a date format, longest first so YYYY wins over YY
+var dateLayoutTokens = []string{"YYYY", "YY", "MONTH", "Month", "month", "MON", "Mon", "mon", "MM", "M", "DD", "D"}
+
+// parsedDate is a date of which the day and month may be unknown (0), for targets like 1990
+// or May 1990
+type parsedDate struct {
+	year  int
+	month int
+	day   int
+}
+
+var (
+	datePartsRe  = regexp.MustCompile(`[0-9]+|[A-Za-z]+`)
+	dateDigitsRe = regexp.MustCompile(`^[0-9]{8}$`)
+)
+
+// parseDate recognises common ways of writing a date. Numeric dates without a leading year
+// are read in order (dmy or mdy). Bare numbers only count as a date when strict is false,
+// so detection does not mistake any 4 or 8 digit target for a date.
+func parseDate(value, order string, strict bool) (parsedDate, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return parsedDate{}, false
+	}
+	if dateDigitsRe.MatchString(value) {
+		if strict {
+			return parsedDate{}, false
+		}
+		// 19900512 or 12051990 / 05121990
+		if d, ok := numericDate(value[0:4], value[4:6], value[6:8], "ymd"); ok {
+			return d, true
+		}
+		return numericDate(value[0:2], value[2:4], value[4:8], order)
+	}
+
+	// besides numbers and month names there may only be separators
+	for _, r := range datePartsRe.ReplaceAllString(value, "") {
+		if !strings.ContainsRune(" -/.,", r) {
+			return parsedDate{}, false
+		}
+	}
+	parts := datePartsRe.FindAllString(value, -1)
+
+	var numbers []string
+	month := 0
+	for _, part := range parts {
+		if part[0] > '9' {
+			m := monthByName(part)
+			if m == 0 || month != 0 {
+				return parsedDate{}, false
+			}
+			month = m
+			continue
+		}
+		numbers = append(numbers, part)
+	}
+
+	switch {
+	case month == 0 && len(numbers) == 3:
+		if len(numbers[0]) == 4 {
+			return numericDate(numbers[0], numbers[1], numbers[2], "ymd")
+		}
+		return numericDate(numbers[0], numbers[1], numbers[2], order)
+	case month == 0 && len(numbers) == 1 && len(numbers[0]) == 4 && !strict:
+		return checkDate(parsedDate{year: atoi(numbers[0])})
+	case month != 0 && len(numbers) == 1 && len(numbers[0]) == 4:
+		// May 1990
+		return checkDate(parsedDate{year: atoi(numbers[0]), month: month})
+	case month != 0 && len(numbers) == 2:
+		// 12 May 1990, May 12 1990 and 1990 May 12
+		day, year := numbers[0], numbers[1]
+		if len(day) == 4 {
+			day, year = year, day
+		}
+		if len(year) != 4 && len(year) != 2 {
+			return parsedDate{}, false
+		}
+		return checkDate(parsedDate{year: fullYear(year), month: month, day: atoi(day)})
+	}
+	return parsedDate{}, false
+}
+
+// numericDate builds a date from three numeric parts in the given order (ymd, dmy or mdy)
+func numericDate(a, b, c, order string) (parsedDate, bool) {
+	var d parsedDate
+	switch order {
+	case "ymd":
+		d = parsedDate{year: fullYear(a), month: atoi(b), day: atoi(c)}
+	case dateOrderMDY:
+		d = parsedDate{year: fullYear(c), month: atoi(a), day: atoi(b)}
+	default:
+		d = parsedDate{year: fullYear(c), month: atoi(b), day: atoi(a)}
+	}
+	if d.day == 0 {
+		return parsedDate{}, false
+	}
+	return checkDate(d)
+}
+
+// checkDate rejects impossible dates such as 31/02/1990
+func checkDate(d parsedDate) (parsedDate, bool) {
+	if d.year < 1000 || d.year > 9999 || d.month < 0 || d.month > 12 {
+		return parsedDate{}, false
+	}
+	if d.day != 0 {
+		if d.month == 0 || d.day > time.Date(d.year, time.Month(d.month)+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+			return parsedDate{}, false
+		}
+	}
+	return d, true
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// fullYear turns a two digit year into 19xx or 20xx, whichever is closest to now
+func fullYear(s string) int {
+	year := atoi(s)
+	if len(s) != 2 {
+		return year
+	}
+	century := time.Now().Year() / 100 * 100
+	if year+century > time.Now().Year()+10 {
+		return year + century - 100
+	}
+	return year + century
+}
+
+// monthByName accepts English month names and their three letter abbreviations
+func monthByName(name string) int {
+	name = strings.ToLower(name)
+	if len(name) < 3 {
+		return 0
+	}
+	for m := time.January; m <= time.December; m++ {
+		full := strings.ToLower(m.String())
+		if name == full || name == full[:3] || (name == "sept" && m == time.September) {
+			return int(m)
+		}
+	}
+	return 0
+}
+
+// validateDateFormats checks formats and the order before any output is written
+func validateDateFormats(formats []string, order string) error {
+	if order != dateOrderDMY && order != dateOrderMDY {
+		return fmt.Errorf("unknown date order %q, choose from dmy, mdy", order)
+	}
+	for _, format := range formats {
+		if format == dateRaw {
+			continue
+		}
+		found := false
+		for _, token := range dateLayoutTokens {
+			if strings.Contains(format, token) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("date format %q has no YYYY, YY, MM, M, DD, D, Month or Mon in it", format)
+		}
+	}
+	return nil
+}
+
+// formatDate writes d in a format such as DDMMYYYY or MonYYYY, ok is false when the format
+// needs a part of the date that is unknown
+func formatDate(d parsedDate, format string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(format); {
+		token := ""
+		for _, t := range dateLayoutTokens {
+			if strings.HasPrefix(format[i:], t) {
+				token = t
+				break
+			}
+		}
+		if token == "" {
+			b.WriteByte(format[i])
+			i++
+			continue
+		}
+		i += len(token)
+
+		switch token {
+		case "YYYY":
+			fmt.Fprintf(&b, "%04d", d.year)
+		case "YY":
+			fmt.Fprintf(&b, "%02d", d.year%100)
+		}
+		if token[0] == 'Y' {
+			continue
+		}
+		if d.month == 0 {
+			return "", false
+		}
+		name := time.Month(d.month).String()
+		switch token {
+		case "MONTH":
+			b.WriteString(strings.ToUpper(name))
+		case "Month":
+			b.WriteString(name)
+		case "month":
+			b.WriteString(strings.ToLower(name))
+		case "MON":
+			b.WriteString(strings.ToUpper(name[:3]))
+		case "Mon":
+			b.WriteString(name[:3])
+		case "mon":
+			b.WriteString(strings.ToLower(name[:3]))
+		case "MM":
+			fmt.Fprintf(&b, "%02d", d.month)
+		case "M":
+			fmt.Fprintf(&b, "%d", d.month)
+		case "DD", "D":
+			if d.day == 0 {
+				return "", false
+			}
+			if token == "DD" {
+				fmt.Fprintf(&b, "%02d", d.day)
+			} else {
+				fmt.Fprintf(&b, "%d", d.day)
+			}
+		}
+	}
+	return b.String(), true
+}
+
+// expandDate returns the forms of a date target, in format order and without duplicates
+func expandDate(value string, d parsedDate, formats []string) []string {
+	var forms []string
+	for _, format := range formats {
+		if format == dateRaw {
+			forms = append(forms, value)
+			continue
+		}
+		if form, ok := formatDate(d, format); ok {
+			forms = append(forms, form)
+		}
+	}
+	return removeDuplicates(forms)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// consumerMark is the end of a candidate in the bytes sent to the consumer, with the keyspace
+// position after it
+type consumerMark struct {
+	end      int64
+	position uint64
+}
+
+// consumer is the process started by --exec, reading candidates on its stdin. Writes block
+// while the consumer is busy, so the generation runs at the speed of the consumer.
+type consumer struct {
+	cmd      *exec.Cmd
+	stdin    *os.File
+	sent     int64 // bytes of the candidates written so far, some may still be buffered
+	accepted int64 // bytes written to the pipe, the consumer may not have read all of them
+	err      error // set when the consumer stopped reading
+	marks    []consumerMark
+	position uint64 // position after the last candidate of the dropped marks
+	exited   chan struct{}
+	waitErr  error // how the consumer exited, once exited is closed
+}
+
+// maxPipeSize is the most a pipe can hold, marks are kept until that much was written after them
+const maxPipeSize = 1 << 20
+
+// startConsumer starts command, resuming at keyspace position from
+func startConsumer(command string, from uint64) (*consumer, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	// an os.Pipe rather than cmd.StdinPipe, to ask how much of it is left unread
+	stdout, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer stdout.Close()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdout, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		return nil, fmt.Errorf("starting %s: %w", args[0], err)
+	}
+	c := &consumer{cmd: cmd, stdin: stdin, position: from, exited: make(chan struct{})}
+	go func() {
+		c.waitErr = cmd.Wait()
+		close(c.exited)
+	}()
+	return c, nil
+}
+
+// splitCommand splits a command line on spaces, except within single or double quotes
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed %c in command %q", quote, command)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
+
+func (c *consumer) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.stdin.Write(p)
+	c.accepted += int64(n)
+	c.err = err
+	return n, err
+}
+
+// mark records a candidate of size bytes, written at position
+func (c *consumer) mark(size int, position uint64) {
+	c.sent += int64(size)
+	c.marks = append(c.marks, consumerMark{end: c.sent, position: position})
+	if len(c.marks) >= 1<<16 {
+		c.dropMarks(c.accepted - maxPipeSize)
+	}
+}
+
+// dropMarks forgets the marks up to byte offset end
+func (c *consumer) dropMarks(end int64) {
+	i := 0
+	for i < len(c.marks) && c.marks[i].end <= end {
+		c.position = c.marks[i].position
+		i++
+	}
+	c.marks = append(c.marks[:0], c.marks[i:]...)
+}
+
+// acceptedPosition returns the position after the last candidate the consumer took
+func (c *consumer) acceptedPosition() uint64 {
+	c.dropMarks(c.accepted)
+	return c.position
+}
+
+// resume continues at keyspace position, once everything before it was written to the pipe
+func (c *consumer) resume(position uint64) {
+	c.dropMarks(c.sent)
+	c.position = position
+}
+
+// drain waits until the consumer read what was written to the pipe, where the platform can
+// tell, or exited without reading it
+func (c *consumer) drain() {
+	for pipeUnread(c.stdin) > 0 {
+		select {
+		case <-c.exited:
+			c.err = errors.New("consumer exited")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// gone reports whether the consumer stopped reading before the end of the candidates
+func (c *consumer) gone() bool {
+	return c != nil && c.err != nil
+}
+
+// close ends the input of the consumer and returns its exit code once it exits
+func (c *consumer) close() int {
+	if c.err != nil {
+		// what the consumer left in the pipe was never read, where the platform can tell
+		c.accepted -= pipeUnread(c.stdin)
+	}
+	c.stdin.Close()
+	<-c.exited
+	err := c.waitErr
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return 1
+	}
+	return 0
+}
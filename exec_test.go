@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// takeScript is a consumer reading exactly $1 lines into got.txt. Bash reads a pipe one byte at
+// a time, so it takes no more than it keeps.
+const takeScript = `i=0
+while [ $i -lt $1 ] && IFS= read -r line; do
+  printf '%s\n' "$line" >> got.txt
+  i=$((i+1))
+done
+`
+
+func TestExecSessionRestore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake consumer is a bash script")
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	dir := writeFiles(t, map[string]string{
+		"targets.txt":  numbered("word", 6),
+		"wordlist.txt": numbered("", 12),
+		"take.sh":      takeScript,
+	})
+	args := []string{"targets.txt", "wordlist.txt", "-x", "3"}
+	full := mustTarginator(t, dir, args...)
+
+	// the consumer exits after 1000 candidates, the rest of the run is restored into another one
+	mustTarginator(t, dir, append(args, "--session", "acme", "--exec", "bash take.sh 1000")...)
+	data, err := os.ReadFile(filepath.Join(dir, "acme.session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state sessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Position != 1000 {
+		t.Errorf("session position is %d, want 1000", state.Position)
+	}
+	if state.Stage != nil {
+		t.Errorf("session has the generator stage %+v, which the consumer may not have reached", *state.Stage)
+	}
+	mustTarginator(t, dir, append(args, "--session", "acme", "--restore", "--exec", "bash take.sh 1000000")...)
+
+	got, err := os.ReadFile(filepath.Join(dir, "got.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != full {
+		t.Errorf("consumed %d bytes over two runs, want the %d of the full output", len(got), len(full))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "acme.session")); err == nil {
+		t.Error("session was not removed after the restored run completed")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// targetExpander turns target entries into the target words that get combined. Every form
+// an entry is expanded into stays in the group of that entry, so two forms of one target are
+// never combined with each other. Tokens of a multiword target are the exception: they each
+// get a mask bit of their own and combine with each other, but not with whole-target forms.
+type targetExpander struct {
+	dateFormats []string // nil when dates are not expanded
+	dateOrder   string
+	tokenForms  map[string]bool // nil when targets are not tokenized
+	nicknames   nicknameTable   // nil when names are not expanded
+	nameForms   map[string]bool
+	fragments   string
+	fragmentMin int
+	fragmentMax int
+	leet        leetTable // nil when there is no leet stage
+	leetMax     int
+	caseMode    string
+	caseMax     int
+}
+
+func newTargetExpander(cli CLI) (*targetExpander, error) {
+	e := &targetExpander{
+		dateOrder:   cli.DateOrder,
+		fragments:   cli.Fragments,
+		fragmentMin: cli.FragmentMin,
+		fragmentMax: cli.FragmentMax,
+		caseMode:    cli.CasePermute,
+		caseMax:     cli.CaseMax,
+	}
+	if cli.ExpandDates {
+		e.dateFormats = cli.DateFormats
+		if len(e.dateFormats) == 0 {
+			e.dateFormats = defaultDateFormats
+		}
+		if err := validateDateFormats(e.dateFormats, e.dateOrder); err != nil {
+			return nil, err
+		}
+	}
+	if cli.Tokenize {
+		e.tokenForms = make(map[string]bool)
+		for _, form := range cli.TokenForms {
+			if !isTokenForm(form) {
+				return nil, fmt.Errorf("unknown token form %q, choose from %s", form, strings.Join(tokenForms, ", "))
+			}
+			e.tokenForms[form] = true
+		}
+	}
+	if cli.ExpandNames {
+		e.nameForms = make(map[string]bool)
+		for _, form := range cli.NameForms {
+			if !isNameForm(form) {
+				return nil, fmt.Errorf("unknown name form %q, choose from %s", form, strings.Join(nameForms, ", "))
+			}
+			e.nameForms[form] = true
+		}
+		table, err := loadNicknameTable(cli.Nicknames)
+		if err != nil {
+			return nil, err
+		}
+		e.nicknames = table
+	}
+	if cli.Leet {
+		table, err := loadLeetTable(cli.LeetTable, cli.LeetSub)
+		if err != nil {
+			return nil, err
+		}
+		e.leet = table
+		e.leetMax = cli.LeetMax
+	}
+	return e, nil
+}
+
+func isNameForm(form string) bool {
+	for _, f := range nameForms {
+		if f == form {
+			return true
+		}
+	}
+	return false
+}
+
+func isTokenForm(form string) bool {
+	for _, f := range tokenForms {
+		if f == form {
+			return true
+		}
+	}
+	return false
+}
+
+// expand returns the target words of all entries
+func (e *targetExpander) expand(entries []targetEntry, debug bool) []targetWord {
+	var words []targetWord
+	for group, entry := range entries {
+		category := entry.category()
+		forms := append([]string{entry.Value}, entry.Aliases...)
+
+		if e.dateFormats != nil {
+			// declared dates may be written as bare numbers, undeclared ones need separators
+			declared := entry.Category == categoryDate
+			if entry.Category == "" || declared {
+				if d, ok := parseDate(entry.Value, e.dateOrder, !declared); ok {
+					forms = append(expandDate(entry.Value, d, e.dateFormats), entry.Aliases...)
+					category = categoryDate
+				} else if declared && debug {
+					log.Printf("Target %q is not a date that can be expanded", entry.Value)
+				}
+			}
+		}
+
+		var tokens []string
+		if e.tokenForms != nil && category != categoryDate {
+			tokens = splitTokens(entry.Value)
+			if len(tokens) > maxTokens {
+				if debug {
+					log.Printf("Target %q has more than %d tokens and is not tokenized", entry.Value, maxTokens)
+				}
+				tokens = nil
+			}
+			if len(tokens) > 1 {
+				forms = append(forms, joinTokens(tokens, e.tokenForms)...)
+			} else {
+				tokens = nil
+			}
+		}
+
+		// whole-target forms cover the mask bits of every token
+		wholeMask := uint64(1)
+		if len(tokens) > 0 {
+			wholeMask = 1<<(len(tokens)-1)<<1 - 1
+		}
+		masks := make(map[string]uint64)
+		var order []string
+		add := func(forms []string, mask uint64) {
+			for _, form := range removeDuplicates(forms) {
+				if _, ok := masks[form]; !ok {
+					masks[form] = mask
+					order = append(order, form)
+				}
+			}
+		}
+
+		isName := entry.Category == categoryName || (entry.Category == "" && e.nicknames.knows(entry.Value))
+		add(e.variants(forms, isName), wholeMask)
+		if e.tokenForms[tokenTokens] {
+			for i, token := range tokens {
+				// undeclared targets are names when the nickname table knows one of their tokens
+				tokenIsName := entry.Category == categoryName || (entry.Category == "" && e.nicknames.knows(token))
+				isName = isName || tokenIsName
+				add(e.variants([]string{token}, tokenIsName), 1<<i)
+			}
+		}
+		if isName && e.nicknames != nil {
+			category = categoryName
+		}
+
+		// forms share the weight of their target, the further down the expansion the less
+		for i, form := range order {
+			words = append(words, targetWord{
+				word:     form,
+				category: category,
+				group:    group,
+				mask:     masks[form],
+				weight:   entry.Weight / float64(i+1),
+			})
+		}
+	}
+	return words
+}
+
+// variants runs forms through the name, fragment, leet and case stages
+func (e *targetExpander) variants(forms []string, isName bool) []string {
+	if e.nicknames != nil && isName {
+		var names []string
+		for _, form := range forms {
+			names = append(names, expandName(form, e.nicknames, e.nameForms)...)
+		}
+		forms = names
+	}
+
+	if e.fragments != fragmentNone {
+		for _, form := range forms {
+			forms = append(forms, expandFragments(form, e.fragments, e.fragmentMin, e.fragmentMax)...)
+		}
+	}
+
+	if e.leet != nil {
+		for _, form := range forms {
+			forms = append(forms, expandLeet(form, e.leet, e.leetMax)...)
+		}
+	}
+
+	if e.caseMode != caseNone {
+		for _, form := range forms {
+			forms = append(forms, expandCase(form, e.caseMode, e.caseMax)...)
+		}
+	}
+	return forms
+}
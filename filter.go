@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// regexFilter keeps words matching any include expression (when there are any) and drops
+// words matching any exclude expression. A nil filter keeps everything.
+type regexFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newRegexFilter(include, exclude []string) (*regexFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &regexFilter{}
+	for _, expr := range include {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include regex %q: %w", expr, err)
+		}
+		f.include = append(f.include, re)
+	}
+	for _, expr := range exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude regex %q: %w", expr, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+// allows reports whether word passes the filter
+func (f *regexFilter) allows(word string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(word) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the words that pass the filter, keeping their order
+func (f *regexFilter) apply(words []string) []string {
+	if f == nil {
+		return words
+	}
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		if f.allows(word) {
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+// targetFilter is the element filter for target words, including the ones made by target rules
+func targetFilter(cli CLI) *regexFilter {
+	f, err := newRegexFilter(cli.TargetIncludeRegex, cli.TargetExcludeRegex)
+	if err != nil {
+		failGeneration(err)
+	}
+	return f
+}
+
+// wordlistFilter is the element filter for wordlist words, applied after the wordlist rules
+func wordlistFilter(cli CLI) *regexFilter {
+	f, err := newRegexFilter(cli.WordlistIncludeRegex, cli.WordlistExcludeRegex)
+	if err != nil {
+		failGeneration(err)
+	}
+	return f
+}
@@ -0,0 +1,28 @@
+package main
+
+// fragment modes for target words
+const (
+	fragmentNone   = "none"
+	fragmentPrefix = "prefix"
+	fragmentSuffix = "suffix"
+	fragmentBoth   = "both"
+)
+
+// expandFragments returns the prefixes and/or suffixes of word that are minLength to maxLength
+// characters long, shortest first. maxLength 0 stops one character short of the word itself.
+func expandFragments(word, mode string, minLength, maxLength int) []string {
+	runes := []rune(word)
+	if maxLength <= 0 || maxLength >= len(runes) {
+		maxLength = len(runes) - 1
+	}
+	var fragments []string
+	for n := max(minLength, 1); n <= maxLength; n++ {
+		if mode == fragmentPrefix || mode == fragmentBoth {
+			fragments = append(fragments, string(runes[:n]))
+		}
+		if mode == fragmentSuffix || mode == fragmentBoth {
+			fragments = append(fragments, string(runes[len(runes)-n:]))
+		}
+	}
+	return removeDuplicates(fragments)
+}
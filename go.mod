@@ -5,4 +5,7 @@ go 1.24.3
 require (
 	github.com/alecthomas/kong v1.10.0
 	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.17
+	gopkg.in/yaml.v3 v3.0.1
 )
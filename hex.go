@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"log"
 	"strings"
+	"unicode/utf8"
 )
 
 // de-$HEX[] lines
@@ -46,3 +47,33 @@ func checkForHex(line string) string {
 	}
 	return string(lineDecode)
 }
+
+// output $HEX[] modes
+const (
+	hexAuto   = "auto"
+	hexAlways = "always"
+	hexNever  = "never"
+)
+
+// needsHex reports whether line can not be written to hashcat as-is, the same way hashcat
+// decides for --outfile-autohex: separators, control characters (including the newlines that
+// would split the candidate), invalid UTF-8 and lines that would be mistaken for $HEX[]
+func needsHex(line string) bool {
+	if strings.HasPrefix(line, "$HEX[") || !utf8.ValidString(line) {
+		return true
+	}
+	for i := 0; i < len(line); i++ {
+		if c := line[i]; c < 0x20 || c == 0x7f || c == ':' {
+			return true
+		}
+	}
+	return false
+}
+
+// $HEX[] encode lines according to mode
+func encodeHex(line string, mode string) string {
+	if mode == hexNever || (mode == hexAuto && !needsHex(line)) {
+		return line
+	}
+	return "$HEX[" + hex.EncodeToString([]byte(line)) + "]"
+}
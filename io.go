@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
@@ -9,8 +12,103 @@ import (
 	"sort"
 	"strings"
 	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+	// a bzip2 stream is "BZh", a block size level and the magic of its first block, or of its
+	// end when it is empty
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
 )
 
+// isBzip2 tells if magic starts a bzip2 stream, not just a plain text starting with "BZh"
+func isBzip2(magic []byte) bool {
+	if len(magic) < len(bzip2Magic)+1+len(bzip2BlockMagic) || !bytes.HasPrefix(magic, bzip2Magic) {
+		return false
+	}
+	if level := magic[len(bzip2Magic)]; level < '1' || level > '9' {
+		return false
+	}
+	block := magic[len(bzip2Magic)+1:]
+	return bytes.HasPrefix(block, bzip2BlockMagic) || bytes.HasPrefix(block, bzip2EndMagic)
+}
+
+// inputFile is an opened input with any compression layer stripped off
+type inputFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *inputFile) Close() error {
+	var firstErr error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		if err := f.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// compressed tells if a compression layer was stripped off
+func (f *inputFile) compressed() bool {
+	_, plain := f.Reader.(*bufio.Reader)
+	return !plain
+}
+
+// openInput opens a target, wordlist or rules file and transparently decompresses it when
+// its magic bytes say it is gzip, bzip2, zstd or xz compressed. Archive member paths made by
+// listArchiveMembers are streamed from their archive.
+func openInput(path string) (io.ReadCloser, error) {
+	if archive, member, ok := splitArchivePath(path); ok {
+		return openArchiveMember(archive, member)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return newInput(file, file, path)
+}
+
+// newInput detects the compression of r, closer is closed together with the result
+func newInput(r io.Reader, closer io.Closer, name string) (*inputFile, error) {
+	buffered := bufio.NewReaderSize(r, 1<<20) // 1 MiB buffer
+	in := &inputFile{Reader: buffered, closers: []io.Closer{closer}}
+	magic, _ := buffered.Peek(len(bzip2Magic) + 1 + len(bzip2BlockMagic))
+
+	var err error
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(buffered); err == nil {
+			in.Reader = gz
+			in.closers = append(in.closers, gz)
+		}
+	case isBzip2(magic):
+		in.Reader = bzip2.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(buffered); err == nil {
+			in.Reader = zr
+			in.closers = append(in.closers, zr.IOReadCloser())
+		}
+	case bytes.HasPrefix(magic, xzMagic):
+		in.Reader, err = xz.NewReader(buffered)
+	}
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("decompressing %s: %w", name, err)
+	}
+	return in, nil
+}
+
 func isDirectory(path string) (bool, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -20,10 +118,11 @@ func isDirectory(path string) (bool, error) {
 }
 
 func isReadable(path string) (bool, error) {
-	_, err := os.Open(path)
+	file, err := openInput(path)
 	if err != nil {
 		return false, err
 	}
+	file.Close()
 	return true, nil
 }
 
@@ -36,7 +135,7 @@ func loadTargetFile(path string) ([]string, error) {
 		return nil, err
 	}
 	// open file
-	file, err := os.Open(path)
+	file, err := openInput(path)
 	if err != nil {
 		return nil, fmt.Errorf("opening target file %s: %w", path, err)
 	}
@@ -60,7 +159,7 @@ func loadTargetFile(path string) ([]string, error) {
 }
 
 func loadRulesFast(inputFile string) ([]*ruleObj, error) {
-	file, err := os.Open(inputFile)
+	file, err := openInput(inputFile)
 	if err != nil {
 		return nil, fmt.Errorf("opening rules file %s: %w", inputFile, err)
 	}
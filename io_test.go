@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bzip2Alpha is "BZhang\nalpha\n" compressed with bzip2
+var bzip2Alpha = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x27, 0x25, 0x34, 0x2a, 0x00, 0x00,
+	0x01, 0x47, 0x80, 0x00, 0x10, 0x10, 0x00, 0x00, 0x10, 0x20, 0xc5, 0x40, 0x00, 0x20, 0x00, 0x22,
+	0x0c, 0x9a, 0x64, 0x20, 0xc9, 0x88, 0x3a, 0x0a, 0x18, 0xe3, 0x75, 0x3c, 0x5d, 0xc9, 0x14, 0xe1,
+	0x42, 0x40, 0x9c, 0x94, 0xd0, 0xa8,
+}
+
+func TestOpenInputBzip2Detection(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"plain starting with BZh", []byte("BZhang\nalpha\n"), "BZhang\nalpha\n"},
+		{"plain with a level", []byte("BZh9 blocks\n"), "BZh9 blocks\n"},
+		{"plain shorter than a header", []byte("BZh"), "BZh"},
+		{"bzip2", bzip2Alpha, "BZhang\nalpha\n"},
+		{"empty bzip2", []byte{'B', 'Z', 'h', '9', 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0x00, 0x00, 0x00, 0x00}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "input")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			in, err := openInput(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+			got, err := io.ReadAll(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// join styles, applied to the elements of a candidate while it is assembled
+const (
+	styleVerbatim = "verbatim"
+	styleCamel    = "camel"
+	stylePascal   = "pascal"
+	styleLower    = "lower"
+	styleUpper    = "upper"
+	styleFirst    = "first"
+)
+
+var joinStyles = []string{styleVerbatim, styleCamel, stylePascal, styleLower, styleUpper, styleFirst}
+
+func validateJoinStyles(styles []string) error {
+	for _, style := range styles {
+		found := false
+		for _, s := range joinStyles {
+			found = found || s == style
+		}
+		if !found {
+			return fmt.Errorf("unknown join style %q, choose from %s", style, strings.Join(joinStyles, ", "))
+		}
+	}
+	return nil
+}
+
+// joinStyled joins elements with sep after styling each element:
+// camel jamesBond, pascal JamesBond, lower jamesbond, upper JAMESBOND and first Jamesbond,
+// which only capitalizes the first element and leaves the others as they are
+func joinStyled(elements []string, sep, style string) string {
+	if style == styleVerbatim {
+		return strings.Join(elements, sep)
+	}
+	styled := make([]string, len(elements))
+	for i, element := range elements {
+		switch {
+		case style == styleLower || (style == styleCamel && i == 0):
+			styled[i] = strings.ToLower(element)
+		case style == styleUpper:
+			styled[i] = strings.ToUpper(element)
+		case style == styleCamel || style == stylePascal || (style == styleFirst && i == 0):
+			styled[i] = titleCase(element)
+		default:
+			styled[i] = element
+		}
+	}
+	return strings.Join(styled, sep)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// builtinLeet is the substitution table used when no --leet-table is given
+var builtinLeet = []string{"a=4,@", "b=8", "e=3", "g=9", "i=1,!", "l=1", "o=0", "s=5,$", "t=7", "z=2"}
+
+// leetTable maps a lower case character to the strings it can be replaced with
+type leetTable map[rune][]string
+
+// add parses a substitution such as a=4,@ and merges it into the table
+func (t leetTable) add(sub string) error {
+	from, to, ok := strings.Cut(sub, "=")
+	r, size := utf8.DecodeRuneInString(from)
+	if !ok || size != len(from) || to == "" {
+		return fmt.Errorf("invalid leet substitution %q, expected a single character like a=4,@", sub)
+	}
+	r = unicode.ToLower(r)
+	for _, replacement := range strings.Split(to, ",") {
+		if replacement != "" {
+			t[r] = append(t[r], replacement)
+		}
+	}
+	t[r] = removeDuplicates(t[r])
+	return nil
+}
+
+// loadLeetTable builds the substitution table from a table file (or the built-in table when
+// path is empty) and extra substitutions. Table files hold one substitution like a=4,@ per line.
+func loadLeetTable(path string, extra []string) (leetTable, error) {
+	table := make(leetTable)
+	subs := builtinLeet
+	if path != "" {
+		file, err := openInput(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening leet table %s: %w", path, err)
+		}
+		defer file.Close()
+		subs = nil
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				subs = append(subs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading leet table %s: %w", path, err)
+		}
+	}
+	for _, sub := range append(subs, extra...) {
+		if err := table.add(sub); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// expandLeet returns the leet variants of word with at least one and at most maxSubs
+// substituted characters (0 is no limit), without the word itself. Variants are ordered by
+// position, leaving a character as is before trying its replacements.
+func expandLeet(word string, table leetTable, maxSubs int) []string {
+	runes := []rune(word)
+	var variants []string
+	var walk func(pos, subs int, prefix string)
+	walk = func(pos, subs int, prefix string) {
+		if pos == len(runes) {
+			if subs > 0 {
+				variants = append(variants, prefix)
+			}
+			return
+		}
+		r := runes[pos]
+		walk(pos+1, subs, prefix+string(r))
+		if maxSubs > 0 && subs >= maxSubs {
+			return
+		}
+		for _, replacement := range table[unicode.ToLower(r)] {
+			walk(pos+1, subs+1, prefix+replacement)
+		}
+	}
+	walk(0, 0, "")
+	return removeDuplicates(variants)
+}
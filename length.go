@@ -0,0 +1,248 @@
+package main
+
+import "strings"
+
+// lengthBounds restricts the byte length of a joined candidate, separators included.
+// Without hasMax there is no upper limit.
+type lengthBounds struct {
+	min    int
+	max    int
+	hasMax bool
+}
+
+func newLengthBounds(cli CLI) lengthBounds {
+	b := lengthBounds{
+		min:    cli.MinLength,
+		max:    cli.MaxLength,
+		hasMax: cli.MaxLength > 0,
+	}
+	// the length part of a policy preset prunes like the flags do, the stricter one wins
+	if preset, ok := policyPresets[strings.ToLower(cli.Policy)]; ok {
+		b.min = max(b.min, preset.minLength)
+		if preset.maxLength > 0 && (!b.hasMax || preset.maxLength < b.max) {
+			b.max = preset.maxLength
+			b.hasMax = true
+		}
+	}
+	return b
+}
+
+// active reports whether the bounds can reject anything at all
+func (b lengthBounds) active() bool {
+	return b.min > 0 || b.hasMax
+}
+
+// allows reports whether a finished candidate of size bytes fits
+func (b lengthBounds) allows(size int) bool {
+	return size >= b.min && (!b.hasMax || size <= b.max)
+}
+
+// exceeded reports whether a partial candidate of size bytes is already too long,
+// used to prune a branch before it is completed
+func (b lengthBounds) exceeded(size int) bool {
+	return b.hasMax && size > b.max
+}
+
+// shrink returns the bounds left over once n bytes are reserved for something else,
+// such as an inserted wordlist word and its separator
+func (b lengthBounds) shrink(n int) lengthBounds {
+	return lengthBounds{min: b.min - n, max: b.max - n, hasMax: b.hasMax}
+}
+
+// upperOnly drops the minimum, for bounding a part of a candidate that will be extended later
+func (b lengthBounds) upperOnly() lengthBounds {
+	return lengthBounds{max: b.max, hasMax: b.hasMax}
+}
+
+// bucketLimit is the first length that no query against these bounds needs to tell apart
+// from longer ones, so counting tables can stop there
+func (b lengthBounds) bucketLimit() int {
+	if b.hasMax {
+		return b.max + 1
+	}
+	return b.min + 1
+}
+
+// permutationLengthCounts counts the ordered selections of k combinable target words,
+// bucketed by their summed byte length. Sums of limit bytes or more share the last bucket.
+func permutationLengthCounts(targets []targetWord, k, limit int) []uint64 {
+	// subsets[j][l] is the number of j-sized sets of combinable words summing to l bytes
+	subsets := newLengthTable(k, limit)
+	subsets[0][0] = 1
+	for _, group := range groupTargetWords(targets) {
+		if !exclusiveGroup(group) {
+			subsets = convolveLengthTables(subsets, groupLengthTable(group, k, limit), limit)
+			continue
+		}
+		// at most one word of the group is used, so each set grows by one of them or not at all
+		for j := k; j >= 1; j-- {
+			for _, t := range group {
+				for l := 0; l <= limit; l++ {
+					if c := subsets[j-1][l]; c != 0 {
+						subsets[j][min(l+len(t.word), limit)] += c
+					}
+				}
+			}
+		}
+	}
+
+	// every set can be ordered in k! ways
+	var orderings uint64 = 1
+	for i := 2; i <= k; i++ {
+		orderings *= uint64(i)
+	}
+	counts := subsets[k]
+	for l := range counts {
+		counts[l] *= orderings
+	}
+	return counts
+}
+
+func newLengthTable(k, limit int) [][]uint64 {
+	table := make([][]uint64, k+1)
+	for j := range table {
+		table[j] = make([]uint64, limit+1)
+	}
+	return table
+}
+
+// groupTargetWords splits target words by group, in order of first appearance
+func groupTargetWords(targets []targetWord) [][]targetWord {
+	index := make(map[int]int)
+	var groups [][]targetWord
+	for _, t := range targets {
+		i, ok := index[t.group]
+		if !ok {
+			i = len(groups)
+			index[t.group] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], t)
+	}
+	return groups
+}
+
+// exclusiveGroup reports whether no two words of the group can be combined
+func exclusiveGroup(group []targetWord) bool {
+	for _, t := range group {
+		if t.mask != group[0].mask {
+			return false
+		}
+	}
+	return true
+}
+
+// groupLengthTable counts the sets of combinable words within a single group, by size and length
+func groupLengthTable(group []targetWord, k, limit int) [][]uint64 {
+	// sets are tracked per union of masks, as that decides which words can still be added
+	states := map[uint64][][]uint64{0: newLengthTable(k, limit)}
+	states[0][0][0] = 1
+	for _, t := range group {
+		var sources []uint64
+		for mask := range states {
+			if mask&t.mask == 0 {
+				sources = append(sources, mask)
+			}
+		}
+		for _, mask := range sources {
+			from := states[mask]
+			to, ok := states[mask|t.mask]
+			if !ok {
+				to = newLengthTable(k, limit)
+				states[mask|t.mask] = to
+			}
+			for j := 0; j < k; j++ {
+				for l := 0; l <= limit; l++ {
+					if c := from[j][l]; c != 0 {
+						to[j+1][min(l+len(t.word), limit)] += c
+					}
+				}
+			}
+		}
+	}
+
+	table := newLengthTable(k, limit)
+	for _, state := range states {
+		for j := range state {
+			for l := range state[j] {
+				table[j][l] += state[j][l]
+			}
+		}
+	}
+	return table
+}
+
+// convolveLengthTables combines the sets counted in a and b, capped at the size of a
+func convolveLengthTables(a, b [][]uint64, limit int) [][]uint64 {
+	k := len(a) - 1
+	result := newLengthTable(k, limit)
+	for j1 := 0; j1 <= k; j1++ {
+		for l1 := 0; l1 <= limit; l1++ {
+			if a[j1][l1] == 0 {
+				continue
+			}
+			for j2 := 0; j1+j2 <= k && j2 < len(b); j2++ {
+				for l2 := 0; l2 <= limit; l2++ {
+					if b[j2][l2] != 0 {
+						result[j1+j2][min(l1+l2, limit)] += a[j1][l1] * b[j2][l2]
+					}
+				}
+			}
+		}
+	}
+	return result
+}
+
+// sumLengthCounts sums the buckets of a permutationLengthCounts table that fit in bounds,
+// after subtracting the separators placed between the words
+func sumLengthCounts(counts []uint64, b lengthBounds, separators int) uint64 {
+	limit := len(counts) - 1
+	lo := max(b.min-separators, 0)
+	hi := limit
+	if b.hasMax {
+		hi = min(b.max-separators, limit)
+	}
+	var total uint64
+	for l := lo; l <= hi; l++ {
+		total += counts[l]
+	}
+	return total
+}
+
+// comboCounter answers how many combinations processLength generates for a combo size and
+// length window, without generating them
+type comboCounter struct {
+	sepLen int
+	all    [][]uint64 // permutations of every usable word, indexed by combo size
+	plain  [][]uint64 // permutations without a ruled word, these are never generated
+}
+
+func newComboCounter(targetFile []targetWord, ruledFile []string, maxSize int, bounds lengthBounds, sepLen int) *comboCounter {
+	c := &comboCounter{sepLen: sepLen}
+	limit := bounds.bucketLimit()
+	words := targetFile
+	if len(ruledFile) > 0 {
+		// mirror the de-duplication done in generateRuledCombinationsIter
+		dict, ruled := ruledTargetSet(targetFile, ruledFile)
+		words = append(append([]targetWord{}, dict...), ruled...)
+		c.plain = make([][]uint64, maxSize+1)
+		for k := 1; k <= maxSize; k++ {
+			c.plain[k] = permutationLengthCounts(dict, k, limit)
+		}
+	}
+	c.all = make([][]uint64, maxSize+1)
+	for k := 1; k <= maxSize; k++ {
+		c.all[k] = permutationLengthCounts(words, k, limit)
+	}
+	return c
+}
+
+// count returns the number of size k combos whose joined length fits in bounds
+func (c *comboCounter) count(k int, bounds lengthBounds) uint64 {
+	separators := c.sepLen * (k - 1)
+	total := sumLengthCounts(c.all[k], bounds, separators)
+	if c.plain != nil {
+		total -= sumLengthCounts(c.plain[k], bounds, separators)
+	}
+	return total
+}
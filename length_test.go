@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestLengthBoundsKeyspace(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"targets.txt":  "ab\ncde\nfghi\nj\nklmno\n",
+		"wordlist.txt": "1\n22\n333\n",
+		"rules.rule":   ":\nu\n$1\n$2 $3\n",
+	})
+	for _, tt := range []struct {
+		name     string
+		options  []string
+		min, max int
+	}{
+		{"no bounds", nil, 0, 0},
+		{"minimum", nil, 9, 0},
+		{"maximum", nil, 0, 7},
+		{"window", nil, 6, 9},
+		{"one length", nil, 8, 8},
+		{"nothing fits", nil, 40, 0},
+		{"separator", []string{"-s", "_"}, 6, 10},
+		{"long separator", []string{"-s", "._."}, 0, 11},
+		{"target rules", []string{"-t", "rules.rule"}, 5, 10},
+		{"target rules and separator", []string{"-t", "rules.rule", "-s", "_"}, 7, 12},
+		{"wordlist rules", []string{"-r", "rules.rule"}, 4, 8},
+		{"all", []string{"-m", "2", "-t", "rules.rule", "-r", "rules.rule", "-s", "_"}, 8, 13},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"targets.txt", "wordlist.txt", "-x", "3"}, tt.options...)
+			// the candidates of the bounds are those of the unbounded run that fit
+			var want []string
+			for _, candidate := range lines(mustTarginator(t, dir, args...)) {
+				if len(candidate) >= tt.min && (tt.max == 0 || len(candidate) <= tt.max) {
+					want = append(want, candidate)
+				}
+			}
+			args = append(args, "--min-length", strconv.Itoa(tt.min), "--max-length", strconv.Itoa(tt.max))
+			if got := lines(mustTarginator(t, dir, args...)); !slices.Equal(got, want) {
+				t.Errorf("wrote %d candidates, want the %d of the unbounded run that fit", len(got), len(want))
+			}
+			if keyspace := keyspaceOfRun(t, dir, args...); keyspace != uint64(len(want)) {
+				t.Errorf("keyspace %d, want %d", keyspace, len(want))
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/alecthomas/kong"
 	"log"
+	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 /*
@@ -36,30 +40,119 @@ type lineObj struct {
 	line string
 }
 
+// commands of the tool, generating candidates is the default
+type commands struct {
+	Generate   CLI           `cmd:"" default:"withargs" help:"Generate candidates from a target file and wordlists"`
+	Train      TrainCLI      `cmd:"" help:"Train a character level Markov model on a list of found passwords"`
+	Serve      ServeCLI      `cmd:"" help:"Serve candidates of submitted jobs over HTTP"`
+	Coordinate CoordinateCLI `cmd:"" help:"Hand out chunks of a job to workers over HTTP and keep track of the finished ones"`
+	Work       WorkCLI       `cmd:"" help:"Generate the chunks a coordinator hands out"`
+}
+
+// TrainCLI holds the arguments of the train command
+type TrainCLI struct {
+	Found      string `arg:"" help:"Path to a list of found passwords"`
+	OutputFile string `optional:"" short:"o" help:"Model file to write" default:"targinator.markov"`
+	Order      int    `optional:"" help:"Characters of context per prediction" default:"3"`
+	Counted    bool   `optional:"" help:"Lines start with a count, as written by uniq -c" default:"false"`
+	PCFGFile   string `optional:"" name:"pcfg-file" help:"Also write the base structures (L6D4S1) of the found list and their counts to this file" default:""`
+}
+
 type CLI struct {
-	Target             string   `arg:"" help:"Path to target data file (must fit in memory)"`
-	Wordlists          []string `optional:"" arg:"" help:"Path to wordlist files or directory"`
-	MinTarget          int      `optional:"" short:"m" help:"Minimum target occurrences" default:"1"`
-	MaxTarget          int      `optional:"" short:"x" help:"Maximum target occurrences" default:"3"`
-	TargetRules        string   `optional:"" short:"t" help:"Apply rules file to Target" default:""`
-	WordlistRules      string   `optional:"" short:"r" help:"Apply rules file to Wordlist, warning: forces wordlist memory" default:""`
-	Separator          string   `optional:"" short:"s" help:"Word Separator" default:""`
-	OutputFile         string   `optional:"" short:"o" help:"Output File" default:""`
-	Keyspace           bool     `optional:"" help:"Show keyspace for attack (used for HTP)" default:"false"`
-	Skip               uint64   `optional:"" help:"Skip initial N generated candidates (used for HTP)" default:"0"`
-	Limit              uint64   `optional:"" help:"Stop attack early after N generated candidates (used for HTP)" default:"0"`
-	SelfCombination    bool     `optional:"" help:"Combine without using a wordlist [default: True]" default:"true"`
-	PartialDeduplicate bool     `optional:"" help:"Help reduce the amount of duplicates" default:"false"`
-	Debug              bool     `optional:"" help:"Show Debug Messages" default:"false"`
+	Target               string            `arg:"" help:"Path to target data file (must fit in memory)"`
+	Wordlists            []string          `optional:"" arg:"" help:"Path to wordlist files or directory"`
+	MinTarget            int               `optional:"" short:"m" help:"Minimum target occurrences" default:"1"`
+	MaxTarget            int               `optional:"" short:"x" help:"Maximum target occurrences" default:"3"`
+	ExpandDates          bool              `optional:"" help:"Expand date targets into the forms used in passwords (1990, 90, 0512, May1990, ...)" default:"false"`
+	DateFormats          []string          `optional:"" help:"Date forms to expand into, such as DDMMYYYY or MonYYYY, raw keeps the date as written"`
+	DateOrder            string            `optional:"" enum:"dmy,mdy" help:"Day and month order of numeric dates such as 05/12/1990" default:"dmy"`
+	Tokenize             bool              `optional:"" help:"Split multiword targets such as John Michael Smith into tokens that combine on their own" default:"false"`
+	TokenForms           []string          `optional:"" help:"Forms of multiword targets (tokens, initials, squashed, firstlast)" default:"tokens,initials,squashed,firstlast"`
+	ExpandNames          bool              `optional:"" help:"Expand name targets with nicknames, initials and case variants" default:"false"`
+	Nicknames            string            `optional:"" help:"Extra nicknames file, each line lists names that are forms of each other (robert,rob,bob)" default:""`
+	NameForms            []string          `optional:"" help:"Name forms to expand into (nicknames, initial, lower, upper, title)" default:"nicknames,initial,lower,upper,title"`
+	Fragments            string            `optional:"" enum:"none,prefix,suffix,both" help:"Add prefixes and/or suffixes of target words, such as Jam from James" default:"none"`
+	FragmentMin          int               `optional:"" help:"Minimum characters of a fragment" default:"3"`
+	FragmentMax          int               `optional:"" help:"Maximum characters of a fragment (0 is one less than the word)" default:"0"`
+	Leet                 bool              `optional:"" help:"Add leetspeak variants of target words" default:"false"`
+	LeetMax              int               `optional:"" help:"Maximum substituted characters per leet variant (0 is all)" default:"0"`
+	LeetTable            string            `optional:"" help:"Leet substitution table replacing the built-in one, one substitution like a=4,@ per line" default:""`
+	LeetSub              []string          `optional:"" sep:"none" help:"Extra leet substitution such as a=4,@"`
+	CasePermute          string            `optional:"" enum:"none,all,first,last,ends" help:"Add case variants of target words: every combination (all) or toggling the first, last or both end letters" default:"none"`
+	CaseMax              int               `optional:"" help:"Maximum toggled letters per case variant with --case-permute all (0 is no limit)" default:"0"`
+	TargetRules          string            `optional:"" short:"t" help:"Apply rules file to Target" default:""`
+	ArchiveGlob          []string          `optional:"" help:"Only use zip and tar archive members matching one of these globs, e.g. *.txt"`
+	WordlistRules        string            `optional:"" short:"r" help:"Apply rules file to Wordlist, warning: forces wordlist memory" default:""`
+	Separator            string            `optional:"" short:"s" help:"Word Separator" default:""`
+	JoinStyle            []string          `optional:"" help:"Write every combination in these join styles (verbatim, camel, pascal, lower, upper, first)" default:"verbatim"`
+	MinLength            int               `optional:"" help:"Minimum candidate length, separators included" default:"0"`
+	MaxLength            int               `optional:"" help:"Maximum candidate length, separators included (0 is unlimited)" default:"0"`
+	Policy               string            `optional:"" help:"Only keep candidates allowed by a password policy preset (windows, pci, nist, complex)" default:""`
+	PolicyRequire        []string          `optional:"" help:"Character classes every candidate must contain (lower, upper, digit, symbol, alpha)"`
+	PolicyMinClasses     int               `optional:"" help:"Minimum amount of distinct character classes out of lower, upper, digit and symbol" default:"0"`
+	PolicyBan            []string          `optional:"" help:"Drop candidates containing this case-insensitive substring, such as the username"`
+	IncludeRegex         []string          `optional:"" sep:"none" help:"Only keep candidates matching one of these regexes (RE2)"`
+	ExcludeRegex         []string          `optional:"" sep:"none" help:"Drop candidates matching one of these regexes (RE2)"`
+	TargetIncludeRegex   []string          `optional:"" sep:"none" help:"Only use target words matching one of these regexes (RE2)"`
+	TargetExcludeRegex   []string          `optional:"" sep:"none" help:"Drop target words matching one of these regexes (RE2)"`
+	WordlistIncludeRegex []string          `optional:"" sep:"none" help:"Only use wordlist words matching one of these regexes (RE2)"`
+	WordlistExcludeRegex []string          `optional:"" sep:"none" help:"Drop wordlist words matching one of these regexes (RE2)"`
+	Template             []string          `optional:"" sep:"none" help:"Generate candidates shaped like this template instead of combining, e.g. {name}{sep}{year}{wl:symbols}"`
+	TemplateWordlist     map[string]string `optional:"" help:"Named wordlist for {wl:NAME} template slots, as NAME=PATH"`
+	TemplateSet          map[string]string `optional:"" help:"Named set for {NAME} template slots, as NAME=a|b|c or NAME=1990-2025"`
+	PCFG                 string            `optional:"" name:"pcfg" help:"Fill the base structures of this file (written by train --pcfg-file) with target and wordlist words, most probable first" default:""`
+	Shard                string            `optional:"" help:"Only write slice i of n of the keyspace, such as 2/4, for splitting an attack over machines" default:""`
+	ShardMode            string            `optional:"" enum:"range,stride" help:"Slice the keyspace in contiguous ranges or take every n-th candidate" default:"range"`
+	Sample               uint64            `optional:"" help:"Write N candidates drawn uniformly at random from the whole keyspace, in keyspace order" default:"0"`
+	Seed                 uint64            `optional:"" help:"Random seed for --sample, the same seed draws the same candidates" default:"0"`
+	Session              string            `optional:"" help:"Save the position of the run as NAME.session, to continue an interrupted run with --restore" default:""`
+	Restore              bool              `optional:"" help:"Continue the --session where its output stopped" default:"false"`
+	SessionInterval      time.Duration     `optional:"" help:"How often the --session position is saved" default:"10s"`
+	Status               bool              `optional:"" help:"Report progress on stderr every --status-interval, it is also reported on SIGUSR1" default:"false"`
+	StatusInterval       time.Duration     `optional:"" help:"How often --status reports, 0 only reports on SIGUSR1" default:"10s"`
+	StatusJSON           bool              `optional:"" name:"status-json" help:"Report status as lines of JSON, implies --status" default:"false"`
+	Exec                 string            `optional:"" help:"Feed the candidates to the stdin of this command, such as \"hashcat -m 0 hashes.txt\"" default:""`
+	Runtime              time.Duration     `optional:"" help:"Stop after running this long, such as 90m (0 is no limit)" default:"0"`
+	Order                string            `optional:"" enum:"file,probability" help:"Write candidates in file order, or most probable first by target and wordlist weights" default:"file"`
+	Counted              bool              `optional:"" help:"Lines of plain target files and wordlists start with a count, as written by uniq -c" default:"false"`
+	WeighBy              string            `optional:"" enum:"rank,equal" help:"Weight of lines without a count or weight in probability order: by rank in the file or all equal" default:"rank"`
+	Markov               string            `optional:"" help:"Markov model written by the train command, to score candidates with" default:""`
+	MarkovThreshold      float64           `optional:"" help:"Drop candidates scoring below this Markov score" default:"-1.2"`
+	MarkovDefer          bool              `optional:"" help:"Write candidates scoring below --markov-threshold after all others instead of dropping them" default:"false"`
+	OutputFile           string            `optional:"" short:"o" help:"Output File" default:""`
+	OutputHex            string            `optional:"" enum:"auto,always,never" help:"Write candidates as $HEX[] when they contain ':', control characters or invalid UTF-8 (auto), always or never" default:"auto"`
+	Keyspace             bool              `optional:"" help:"Show keyspace for attack (used for HTP)" default:"false"`
+	Skip                 uint64            `optional:"" help:"Skip initial N generated candidates (used for HTP)" default:"0"`
+	Limit                uint64            `optional:"" help:"Stop attack early after N generated candidates (used for HTP)" default:"0"`
+	SelfCombination      bool              `optional:"" help:"Combine without using a wordlist [default: True]" default:"true"`
+	PartialDeduplicate   bool              `optional:"" help:"Help reduce the amount of duplicates" default:"false"`
+	Debug                bool              `optional:"" help:"Show Debug Messages" default:"false"`
 }
 
 func main() {
-	var cli CLI
-	kong.Parse(&cli,
+	var cmds commands
+	ctx := kong.Parse(&cmds,
 		kong.Name("Targinator"),
 		kong.Description("A self-combinator using a targeted and generic wordlist - v1.1.0"),
 		kong.UsageOnError(),
 	)
+	if strings.HasPrefix(ctx.Command(), "train") {
+		runTrain(cmds.Train)
+		return
+	}
+	if ctx.Command() == "serve" {
+		runServe(cmds.Serve)
+		return
+	}
+	if strings.HasPrefix(ctx.Command(), "coordinate") {
+		runCoordinate(cmds.Coordinate)
+		return
+	}
+	if strings.HasPrefix(ctx.Command(), "work") {
+		runWork(cmds.Work)
+		return
+	}
+	cli := cmds.Generate
 
 	// Get the target list and exit if invalid
 	if cli.Debug {
@@ -71,36 +164,312 @@ func main() {
 		return
 	}
 
+	g, prepErr := prepareGeneration(cli)
+	if prepErr != nil {
+		log.Fatal(prepErr)
+	}
+
+	if cli.Sample > 0 && (len(cli.Template) > 0 || cli.PCFG != "" || cli.Skip > 0 || cli.Limit > 0) {
+		log.Fatal("Sample can not be used with templates, PCFG structures, skip or limit")
+	}
+
+	var slice *shard
+	if cli.Shard != "" {
+		var shardErr error
+		if slice, shardErr = parseShard(cli.Shard, cli.ShardMode); shardErr != nil {
+			log.Fatal(shardErr)
+		}
+		if cli.Skip > 0 || cli.Limit > 0 || cli.Sample > 0 {
+			log.Fatal("Shard can not be used with skip, limit or sample")
+		}
+	}
+
+	if cli.Exec != "" && cli.OutputFile != "" {
+		log.Fatal("Exec can not be used with an output file")
+	}
+
+	var sess *session
+	if cli.Restore && cli.Session == "" {
+		log.Fatal("Restore needs the --session to continue")
+	}
+	if cli.Session != "" && !cli.Keyspace {
+		if cli.Sample > 0 {
+			log.Fatal("Session can not be used with sample")
+		}
+		sess = newSession(cli)
+		if _, err := os.Stat(sess.path); err == nil && !cli.Restore {
+			log.Fatalf("Session %s exists, continue it with --restore or remove it", sess.path)
+		}
+	}
+
+	if cli.Keyspace {
+		keyspace, keyspaceErr := g.keyspace()
+		if keyspaceErr != nil {
+			log.Fatal(keyspaceErr)
+		}
+		if slice != nil {
+			keyspace = slice.size(keyspace)
+		}
+		fmt.Printf("%d\n", keyspace)
+		return
+	}
+
+	cli.Status = cli.Status || cli.StatusJSON
+	var keyspace uint64 // only calculated when needed
+	if cli.Status || (slice != nil && slice.mode == shardRange) {
+		var keyspaceErr error
+		if keyspace, keyspaceErr = g.keyspace(); keyspaceErr != nil {
+			log.Fatal(keyspaceErr)
+		}
+	}
+	if slice != nil && slice.mode == shardRange {
+		cli.Skip, cli.Limit = slice.rangeOf(keyspace)
+		if cli.Limit == 0 {
+			return
+		}
+	}
+	// the run covers first to end of the keyspace, also when restored
+	first, end := cli.Skip, keyspace
+	if cli.Limit > 0 {
+		end = min(end, cli.Skip+cli.Limit)
+	}
+
+	if cli.Restore {
+		left, restoreErr := sess.restore(&cli)
+		if restoreErr != nil {
+			log.Fatal(restoreErr)
+		}
+		if !left {
+			sess.finish()
+			return
+		}
+	}
+
+	writer := newCandidateWriter(cli, g.policy, g.outputFilter, g.model)
+	defer writer.Flush()
+	if slice != nil && slice.mode == shardStride {
+		writer.stride, writer.strideOffset = slice.count, slice.index-1
+	}
+	handleSignals(writer)
+	if sess != nil {
+		writer.session = sess
+		sess.watch(writer, cli.SessionInterval)
+	}
+	writer.status = newStatusReporter(cli, first, end)
+	handleStatusSignal(writer)
+	if cli.Status {
+		writer.status.watch(writer, cli.StatusInterval)
+	}
+	if cli.Runtime > 0 {
+		time.AfterFunc(cli.Runtime, func() { writer.expired.Store(true) })
+	}
+
+	if runErr := g.run(writer); runErr != nil {
+		log.Fatal(runErr)
+	}
+
+	writer.Flush()
+	state := stateDone
+	if writer.interrupted.Load() {
+		state = stateInterrupted
+	} else if writer.expired.Load() {
+		state = stateRuntime
+	} else if writer.consumer.gone() {
+		state = stateConsumerExited
+	}
+	exitCode := 0
+	if writer.consumer != nil {
+		// the run exits like its consumer, so hashcat's exit code is kept
+		exitCode = writer.consumer.close()
+	}
+	if cli.Status {
+		writer.reportStatus(state)
+	}
+	if state != stateDone {
+		if sess != nil {
+			writer.saveSession()
+			log.Printf("Stopped at candidate %d, continue with --session %s --restore", max(writer.deliveredPosition(), cli.Skip), cli.Session)
+		} else if state == stateConsumerExited {
+			log.Printf("Consumer exited after candidate %d", max(writer.deliveredPosition(), cli.Skip))
+		}
+		// scripts can tell an interrupted run (1) from one that ran out of time (2)
+		switch state {
+		case stateInterrupted:
+			os.Exit(1)
+		case stateRuntime:
+			os.Exit(2)
+		}
+	} else if sess != nil {
+		sess.finish()
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+
+	if cli.Debug {
+		log.Println("Done")
+	}
+}
+
+// keyspaceOf returns the keyspace of the chosen generation mode
+func keyspaceOf(targetFile []targetWord, cli CLI) uint64 {
+	if len(cli.Template) > 0 {
+		return templatesKeyspace(targetFile, cli)
+	}
+	if cli.PCFG != "" {
+		return pcfgKeyspace(targetFile, cli)
+	}
+	return calculateKeyspace(targetFile, cli)
+}
+
+// generation is a validated run: the expanded target words, the filters and the Markov model.
+// Where its candidates go and which part of the keyspace is written is up to the writer.
+type generation struct {
+	cli           CLI
+	targets       []targetWord
+	policy        *passwordPolicy
+	outputFilter  *regexFilter
+	elementFilter *regexFilter
+	model         *markovModel
+}
+
+// prepareGeneration validates cli and loads everything the run needs
+func prepareGeneration(cli CLI) (*generation, error) {
 	if cli.MinTarget <= 0 {
-		log.Fatalf("MinTarget (%d) must be greater than 0", cli.MinTarget)
+		return nil, fmt.Errorf("MinTarget (%d) must be greater than 0", cli.MinTarget)
 	}
 
 	if cli.MinTarget > cli.MaxTarget {
-		log.Fatalf("MinTarget (%d) must be less than or equal to MaxTarget (%d)", cli.MinTarget, cli.MaxTarget)
+		return nil, fmt.Errorf("MinTarget (%d) must be less than or equal to MaxTarget (%d)", cli.MinTarget, cli.MaxTarget)
 	}
 
-	targetFile, tarErr := loadTargetFile(cli.Target)
-	if tarErr != nil {
-		log.Fatal(tarErr)
-		return
+	if cli.MinLength < 0 || cli.MaxLength < 0 {
+		return nil, fmt.Errorf("MinLength (%d) and MaxLength (%d) can not be negative", cli.MinLength, cli.MaxLength)
+	}
+
+	if cli.MaxLength > 0 && cli.MinLength > cli.MaxLength {
+		return nil, fmt.Errorf("MinLength (%d) must be less than or equal to MaxLength (%d)", cli.MinLength, cli.MaxLength)
+	}
+
+	if len(cli.Template) > 0 && cli.TargetRules != "" {
+		return nil, errors.New("Target rules can not be used with templates")
+	}
+
+	if cli.Order == orderProbability && (len(cli.Template) > 0 || cli.TargetRules != "") {
+		return nil, errors.New("Probability order can not be used with templates or target rules")
+	}
+
+	if cli.PCFG != "" && (len(cli.Template) > 0 || cli.TargetRules != "" || cli.Order == orderProbability) {
+		return nil, errors.New("PCFG structures can not be used with templates, target rules or probability order")
+	}
+
+	if err := validateJoinStyles(cli.JoinStyle); err != nil {
+		return nil, err
+	}
+
+	g := &generation{cli: cli}
+	var err error
+	if g.policy, err = policyFromCLI(cli); err != nil {
+		return nil, err
+	}
+	if g.outputFilter, err = newRegexFilter(cli.IncludeRegex, cli.ExcludeRegex); err != nil {
+		return nil, err
+	}
+	if g.elementFilter, err = newRegexFilter(cli.TargetIncludeRegex, cli.TargetExcludeRegex); err != nil {
+		return nil, err
+	}
+	// validate before any output is written
+	if _, err = newRegexFilter(cli.WordlistIncludeRegex, cli.WordlistExcludeRegex); err != nil {
+		return nil, err
 	}
+
+	expander, err := newTargetExpander(cli)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := loadTargets(cli.Target, cli.Counted)
+	if err != nil {
+		return nil, err
+	}
+	weighEntries(targets, cli)
+	g.targets = filterTargetWords(expander.expand(targets, cli.Debug), g.elementFilter)
 	if cli.Debug {
-		log.Printf("Loaded %d target words.", len(targetFile))
+		log.Printf("Loaded %d target words.", len(g.targets))
 	}
 
-	if cli.Keyspace {
-		fmt.Printf("%d\n", calculateKeyspace(targetFile, cli))
-		return
+	if cli.Markov != "" {
+		if g.model, err = loadMarkovModel(cli.Markov); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// generationError stops a generation that can not go on, such as when a wordlist went missing
+// after the run was prepared. The generation functions raise it with failGeneration, run and
+// keyspace return it, so a server fails the one request rather than exiting.
+type generationError struct {
+	err error
+}
+
+// failGeneration stops the generation with err
+func failGeneration(err error) {
+	panic(generationError{err})
+}
+
+// recoverGeneration sets *err to the error of a failGeneration, other panics go on
+func recoverGeneration(err *error) {
+	if r := recover(); r != nil {
+		failure, ok := r.(generationError)
+		if !ok {
+			panic(r)
+		}
+		*err = failure.err
 	}
+}
+
+// keyspace returns the keyspace of the generation
+func (g *generation) keyspace() (keyspace uint64, err error) {
+	defer recoverGeneration(&err)
+	return keyspaceOf(g.targets, g.cli), nil
+}
+
+// run writes the candidates of the generation to writer
+func (g *generation) run(writer *candidateWriter) (err error) {
+	defer recoverGeneration(&err)
+	if g.cli.Sample > 0 {
+		// probability order and deferred candidates only reorder the keyspace, samples are drawn from it as is
+		processSample(g.targets, g.elementFilter, g.cli, writer)
+		return nil
+	}
+	generate(g.targets, g.elementFilter, g.cli, writer)
+	if writer.deferred && !writer.stopped() {
+		// the second pass writes what the first one held back
+		writer.lowPass = true
+		generate(g.targets, g.elementFilter, g.cli, writer)
+	}
+	return nil
+}
 
-	// run target rules on CPU
-	if cli.TargetRules != "" {
+// generate writes the candidates of the chosen generation mode
+func generate(targetFile []targetWord, elementFilter *regexFilter, cli CLI, writer *candidateWriter) {
+	if len(cli.Template) > 0 {
+		processTemplates(targetFile, cli, writer)
+	} else if cli.PCFG != "" {
+		processPCFG(targetFile, cli, writer)
+	} else if cli.Order == orderProbability {
+		processProbabilityOrder(targetFile, cli, writer)
+	} else if cli.TargetRules != "" {
+		// run target rules on CPU
 		targetRuleFile, tarErr := loadRulesFast(cli.TargetRules)
 		if tarErr != nil {
-			log.Fatal(tarErr)
-			return
+			failGeneration(tarErr)
 		}
-		for _, ro := range targetRuleFile {
+		for i, ro := range targetRuleFile {
+			if writer.done() {
+				break
+			}
+			writer.stage.Rule = i
 			if cli.Debug {
 				log.Printf("Running rule: %s", FormatAllRules(ro.RuleLine))
 			}
@@ -109,19 +478,12 @@ func main() {
 			}
 
 			// apply rule in parallel on all cores
-			newWords := applyRuleCPU(ro.RuleLine, targetFile)
-			if cli.PartialDeduplicate {
-				newWords = removeMatchingWords(newWords, targetFile)
-			}
+			newWords := ruledTargetWords(ro, targetFile, elementFilter, cli)
 			if len(newWords) > 0 {
-				processAllWordlists(targetFile, newWords, cli)
+				processAllWordlists(targetFile, newWords, cli, writer)
 			}
 		}
 	} else {
-		processAllWordlists(targetFile, []string{}, cli)
-	}
-
-	if cli.Debug {
-		log.Println("Done")
+		processAllWordlists(targetFile, []string{}, cli, writer)
 	}
 }
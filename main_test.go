@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the test binary as targinator when targinator starts it, so tests can run
+// whole commands in their own processes
+func TestMain(m *testing.M) {
+	if os.Getenv("TARGINATOR_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// targinatorCommand is targinator with args, run in dir
+func targinatorCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "TARGINATOR_TEST_MAIN=1")
+	return cmd
+}
+
+// targinator runs targinator with args in dir and returns what it wrote to stdout
+func targinator(dir string, args ...string) (string, error) {
+	cmd := targinatorCommand(dir, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return string(out), fmt.Errorf("targinator %s: %v: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return string(out), nil
+}
+
+// mustTarginator is targinator failing the test on an error
+func mustTarginator(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := targinator(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// writeFiles writes files, by name, in a new temporary directory and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// numbered returns the lines prefix1 to prefixN
+func numbered(prefix string, n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%s%d\n", prefix, i)
+	}
+	return b.String()
+}
+
+// lines splits output in its lines
+func lines(output string) []string {
+	if output == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+}
+
+// keyspaceOfRun returns the --keyspace of a run with args
+func keyspaceOfRun(t *testing.T, dir string, args ...string) uint64 {
+	t.Helper()
+	out := mustTarginator(t, dir, append(slices.Clone(args), "--keyspace")...)
+	keyspace, err := strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyspace
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// markovEnd is the symbol after the last character of a word
+const markovEnd = 256
+
+// markovBackoff scales the score of a shorter context when the full one never saw a character
+const markovBackoff = 0.4
+
+// markovModel is a character level Markov model of passwords. Words are padded with order zero
+// bytes at the start, so the first characters have a context too, and every context is also
+// counted with its oldest characters dropped to back off to.
+type markovModel struct {
+	order  int
+	counts map[string]map[int]uint64 // context to the count of every next byte or markovEnd
+	totals map[string]uint64
+}
+
+func newMarkovModel(order int) *markovModel {
+	return &markovModel{order: order, counts: make(map[string]map[int]uint64), totals: make(map[string]uint64)}
+}
+
+func (m *markovModel) add(context string, next int, n uint64) {
+	if m.counts[context] == nil {
+		m.counts[context] = make(map[int]uint64)
+	}
+	m.counts[context][next] += n
+	m.totals[context] += n
+}
+
+// train counts every character of word, and its end, in all contexts of up to order characters,
+// n times over for words of a counted list
+func (m *markovModel) train(word string, n uint64) {
+	padded := strings.Repeat("\x00", m.order) + word
+	for i := m.order; i <= len(padded); i++ {
+		next := markovEnd
+		if i < len(padded) {
+			next = int(padded[i])
+		}
+		for c := 0; c <= m.order; c++ {
+			m.add(padded[i-c:i], next, n)
+		}
+	}
+}
+
+// probability of next after context, backing off to shorter contexts for unseen characters
+func (m *markovModel) probability(context string, next int) float64 {
+	weight := 1.0
+	for ; context != ""; context = context[1:] {
+		if n := m.counts[context][next]; n > 0 {
+			return weight * float64(n) / float64(m.totals[context])
+		}
+		weight *= markovBackoff
+	}
+	// every byte and the end stay possible at the bottom
+	return weight * float64(m.counts[""][next]+1) / float64(m.totals[""]+markovEnd+1)
+}
+
+// score is the average log10 probability of the characters of candidate and its end, so
+// candidates of different lengths can be compared. Higher is more password-like.
+func (m *markovModel) score(candidate string) float64 {
+	padded := strings.Repeat("\x00", m.order) + candidate
+	var sum float64
+	for i := m.order; i <= len(padded); i++ {
+		next := markovEnd
+		if i < len(padded) {
+			next = int(padded[i])
+		}
+		sum += math.Log10(m.probability(padded[i-m.order:i], next))
+	}
+	return sum / float64(len(candidate)+1)
+}
+
+// write saves the model as text: a header with the order, then a line per context and next
+// character with its count. Contexts and characters are $HEX[] encoded where needed, an empty
+// character is the end of a word.
+func (m *markovModel) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# targinator markov order %d\n", m.order)
+	contexts := make([]string, 0, len(m.counts))
+	for context := range m.counts {
+		contexts = append(contexts, context)
+	}
+	sort.Strings(contexts)
+	for _, context := range contexts {
+		nexts := make([]int, 0, len(m.counts[context]))
+		for next := range m.counts[context] {
+			nexts = append(nexts, next)
+		}
+		sort.Ints(nexts)
+		for _, next := range nexts {
+			char := ""
+			if next != markovEnd {
+				char = string([]byte{byte(next)})
+			}
+			fmt.Fprintf(bw, "%s\t%s\t%d\n", encodeHex(context, hexAuto), encodeHex(char, hexAuto), m.counts[context][next])
+		}
+	}
+	return bw.Flush()
+}
+
+// loadMarkovModel reads a model written by the train command
+func loadMarkovModel(path string) (*markovModel, error) {
+	file, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening markov model %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	var m *markovModel
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if m == nil {
+			var order int
+			if _, err := fmt.Sscanf(text, "# targinator markov order %d", &order); err != nil || order < 0 {
+				return nil, fmt.Errorf("%s is not a markov model written by targinator train", path)
+			}
+			m = newMarkovModel(order)
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("markov model %s line %d: expected context, character and count", path, line)
+		}
+		count, err := strconv.ParseUint(fields[2], 10, 64)
+		char := checkForHex(fields[1])
+		if err != nil || len(char) > 1 {
+			return nil, fmt.Errorf("markov model %s line %d: invalid entry", path, line)
+		}
+		next := markovEnd
+		if char != "" {
+			next = int(char[0])
+		}
+		m.add(checkForHex(fields[0]), next, count)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading markov model %s: %w", path, err)
+	}
+	if m == nil {
+		return nil, fmt.Errorf("markov model %s is empty", path)
+	}
+	return m, nil
+}
+
+// runTrain builds a markov model from a found list and reports how its own words score,
+// as a guide for --markov-threshold. It can also write the base structures of the list.
+func runTrain(cmd TrainCLI) {
+	if cmd.Order < 1 {
+		log.Fatalf("Order (%d) must be greater than 0", cmd.Order)
+	}
+	words, err := readWordlist(cmd.Found)
+	if err != nil {
+		log.Fatalf("Error reading found list %s: %v", cmd.Found, err)
+	}
+	m := newMarkovModel(cmd.Order)
+	counts := make([]float64, len(words))
+	for i, word := range words {
+		counts[i] = 1
+		if cmd.Counted {
+			word, counts[i] = splitCount(word)
+			words[i] = checkForHex(word)
+		}
+		m.train(words[i], uint64(counts[i]))
+	}
+	if cmd.PCFGFile != "" {
+		if err := writeStructures(cmd.PCFGFile, words, counts); err != nil {
+			log.Fatalf("Error writing structures file: %v", err)
+		}
+	}
+
+	output, err := os.Create(cmd.OutputFile)
+	if err != nil {
+		log.Fatalf("Error creating model file: %v", err)
+	}
+	defer output.Close()
+	if err := m.write(output); err != nil {
+		log.Fatalf("Error writing model file: %v", err)
+	}
+
+	if len(words) > 0 {
+		scores := make([]float64, len(words))
+		for i, word := range words {
+			scores[i] = m.score(word)
+		}
+		sort.Float64s(scores)
+		log.Printf("Trained on %d words, their scores: 10%% below %.3f, 50%% below %.3f, 90%% below %.3f",
+			len(words), scores[len(scores)/10], scores[len(scores)/2], scores[len(scores)*9/10])
+	}
+}
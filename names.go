@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed nicknames.txt
+var builtinNicknames string
+
+// name forms a name target can be expanded into
+const (
+	nameNicknames = "nicknames"
+	nameInitial   = "initial"
+	nameLower     = "lower"
+	nameUpper     = "upper"
+	nameTitle     = "title"
+)
+
+var nameForms = []string{nameNicknames, nameInitial, nameLower, nameUpper, nameTitle}
+
+// nicknameTable maps a lower case name to the other names it is known by
+type nicknameTable map[string][]string
+
+// loadNicknameTable reads the built-in table and optionally a user table on top of it. Every line
+// lists names that are forms of each other, such as robert,rob,bob,bobby.
+func loadNicknameTable(path string) (nicknameTable, error) {
+	table := make(nicknameTable)
+	table.add(strings.NewReader(builtinNicknames))
+	if path == "" {
+		return table, nil
+	}
+	file, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening nicknames file %s: %w", path, err)
+	}
+	defer file.Close()
+	if err := table.add(file); err != nil {
+		return nil, fmt.Errorf("reading nicknames file %s: %w", path, err)
+	}
+	return table, nil
+}
+
+func (t nicknameTable) add(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var names []string
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			for _, other := range names {
+				if other != name {
+					t[name] = append(t[name], other)
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// knows reports whether name is in the table
+func (t nicknameTable) knows(name string) bool {
+	_, ok := t[strings.ToLower(name)]
+	return ok
+}
+
+// related returns the other names of name, in table order and without duplicates
+func (t nicknameTable) related(name string) []string {
+	return removeDuplicates(t[strings.ToLower(name)])
+}
+
+// titleCase upper cases the first letter and lower cases the rest
+func titleCase(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + strings.ToLower(s[size:])
+}
+
+// matchCase writes name in the same style as example: all upper, all lower or title case
+func matchCase(name, example string) string {
+	switch example {
+	case strings.ToUpper(example):
+		return strings.ToUpper(name)
+	case strings.ToLower(example):
+		return strings.ToLower(name)
+	}
+	return titleCase(name)
+}
+
+// expandName returns the value with the name forms enabled in forms: nicknames written like the
+// value, the initial, and lower, UPPER and Title case variants of all of those
+func expandName(value string, table nicknameTable, forms map[string]bool) []string {
+	bases := []string{value}
+	if forms[nameNicknames] {
+		for _, nick := range table.related(value) {
+			bases = append(bases, matchCase(nick, value))
+		}
+	}
+	if r, _ := utf8.DecodeRuneInString(value); forms[nameInitial] && r != utf8.RuneError {
+		bases = append(bases, string(r))
+	}
+
+	var result []string
+	for _, base := range bases {
+		result = append(result, base)
+		if forms[nameLower] {
+			result = append(result, strings.ToLower(base))
+		}
+		if forms[nameUpper] {
+			result = append(result, strings.ToUpper(base))
+		}
+		if forms[nameTitle] {
+			result = append(result, titleCase(base))
+		}
+	}
+	return removeDuplicates(result)
+}
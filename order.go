@@ -0,0 +1,276 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// output orders
+const (
+	orderFile        = "file"
+	orderProbability = "probability"
+)
+
+// ways to weigh lines that come without a count or weight
+const (
+	weighRank  = "rank"
+	weighEqual = "equal"
+)
+
+// splitCount splits a line written by uniq -c, such as "     42 james", into the word and its
+// count. Lines without a count are kept whole with a count of 1.
+func splitCount(line string) (string, float64) {
+	trimmed := strings.TrimLeft(line, " \t")
+	end := 0
+	for end < len(trimmed) && trimmed[end] >= '0' && trimmed[end] <= '9' {
+		end++
+	}
+	if end == 0 || end == len(trimmed) || (trimmed[end] != ' ' && trimmed[end] != '\t') {
+		return line, 1
+	}
+	count, err := strconv.ParseFloat(trimmed[:end], 64)
+	if err != nil {
+		return line, 1
+	}
+	return trimmed[end+1:], count
+}
+
+// rankWeight is the weight of the line at index i of a list sorted by popularity
+func rankWeight(i int) float64 {
+	return 1 / float64(i+1)
+}
+
+// weighEntries weighs the lines of a plain target file by their rank when asked to, structured
+// files and counted lines already carry their weight
+func weighEntries(entries []targetEntry, cli CLI) {
+	if cli.Counted || cli.WeighBy != weighRank || isStructuredTargetFile(cli.Target) {
+		return
+	}
+	for i := range entries {
+		entries[i].Weight = rankWeight(i)
+	}
+}
+
+// weightedWords is a list of words with their log probability, most likely first. Lists of
+// target words keep the target word of every word.
+type weightedWords struct {
+	words   []string
+	logP    []float64
+	targets []targetWord
+}
+
+// newWeightedWords normalizes weights into probabilities and sorts the words by them,
+// keeping the input order for equal weights
+func newWeightedWords(words []string, weights []float64) *weightedWords {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	order := make([]int, len(words))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return weights[order[a]] > weights[order[b]] })
+
+	result := &weightedWords{words: make([]string, len(words)), logP: make([]float64, len(words))}
+	for i, j := range order {
+		result.words[i] = words[j]
+		result.logP[i] = math.Log(weights[j] / total)
+	}
+	return result
+}
+
+// newWeightedTargets is newWeightedWords for target words
+func newWeightedTargets(targets []targetWord) *weightedWords {
+	sorted := append([]targetWord{}, targets...)
+	sort.SliceStable(sorted, func(a, b int) bool { return sorted[a].weight > sorted[b].weight })
+	weights := make([]float64, len(sorted))
+	for i, t := range sorted {
+		weights[i] = t.weight
+	}
+	result := newWeightedWords(wordsOf(sorted), weights)
+	result.targets = sorted
+	return result
+}
+
+// probabilitySpace is a block of candidates made of one word of every dimension. visit writes
+// the candidate of an index, one index into each dimension.
+type probabilitySpace struct {
+	order int // position of the space in the file order
+	dims  []*weightedWords
+	visit func(index []int)
+}
+
+// probabilityNode is a point in a space
+type probabilityNode struct {
+	space *probabilitySpace
+	index []int
+	pivot int // children only advance positions from the pivot on, so every node has one parent
+	logP  float64
+}
+
+type probabilityQueue []*probabilityNode
+
+func (q probabilityQueue) Len() int { return len(q) }
+func (q probabilityQueue) Less(i, j int) bool {
+	if q[i].logP != q[j].logP {
+		return q[i].logP > q[j].logP
+	}
+	// equally probable candidates keep their file order
+	if q[i].space != q[j].space {
+		return q[i].space.order < q[j].space.order
+	}
+	for p := range q[i].index {
+		if q[i].index[p] != q[j].index[p] {
+			return q[i].index[p] < q[j].index[p]
+		}
+	}
+	return false
+}
+func (q probabilityQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *probabilityQueue) Push(x any)   { *q = append(*q, x.(*probabilityNode)) }
+func (q *probabilityQueue) Pop() any {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// walkProbabilityOrder visits the points of all spaces, most probable first, until the writer
+// is done. The probability of a point is the product of the probabilities of its words, given
+// by baseLogP for the space itself. Points are taken from a priority queue that only holds the
+// frontier of every space, walked with the next function of PCFG guessers: as the dimensions
+// are sorted by probability, every node is at most as likely as its parent and popping the
+// queue yields the points in descending probability without enumerating the keyspace first.
+func walkProbabilityOrder(spaces []*probabilitySpace, baseLogP []float64, writer *candidateWriter) {
+	queue := &probabilityQueue{}
+	push := func(space *probabilitySpace, index []int, pivot int, logP float64) {
+		for j, i := range index {
+			logP += space.dims[j].logP[i]
+		}
+		heap.Push(queue, &probabilityNode{space: space, index: index, pivot: pivot, logP: logP})
+	}
+	bases := make(map[*probabilitySpace]float64, len(spaces))
+	for s, space := range spaces {
+		empty := false
+		for _, dim := range space.dims {
+			empty = empty || len(dim.words) == 0
+		}
+		if !empty {
+			bases[space] = baseLogP[s]
+			push(space, make([]int, len(space.dims)), 0, baseLogP[s])
+		}
+	}
+
+	for queue.Len() > 0 && !writer.done() {
+		node := heap.Pop(queue).(*probabilityNode)
+		space := node.space
+		for j := node.pivot; j < len(node.index); j++ {
+			if node.index[j]+1 < len(space.dims[j].words) {
+				child := append([]int{}, node.index...)
+				child[j]++
+				push(space, child, j, bases[space])
+			}
+		}
+		space.visit(node.index)
+	}
+}
+
+// distinctTargets reports whether the target words at index can be combined: no group is used
+// twice unless the masks of its words do not overlap. used is scratch space of groupCount.
+func distinctTargets(dims []*weightedWords, index []int, used []uint64) bool {
+	valid := true
+	for j, i := range index {
+		if dims[j].targets == nil {
+			continue
+		}
+		t := dims[j].targets[i]
+		if used[t.group]&t.mask != 0 {
+			valid = false
+		}
+		used[t.group] |= t.mask
+	}
+	for j, i := range index {
+		if dims[j].targets != nil {
+			used[dims[j].targets[i].group] = 0
+		}
+	}
+	return valid
+}
+
+// processProbabilityOrder writes the same candidates as processAllWordlists, most probable
+// first. The probability of a candidate is the product of the probabilities of its target
+// words and its wordlist word.
+func processProbabilityOrder(targetFile []targetWord, cli CLI, writer *candidateWriter) {
+	if len(targetFile) == 0 {
+		return
+	}
+	targets := newWeightedTargets(targetFile)
+
+	var wordlists []*weightedWords
+	for _, wordlist := range filterByValidWordlistTarget(cli.Wordlists, cli) {
+		words, weights, err := loadWeightedWordlist(wordlist, cli)
+		if err != nil {
+			failGeneration(fmt.Errorf("Error reading wordlist %s: %v", wordlist, err))
+		}
+		wordlists = append(wordlists, newWeightedWords(words, weights))
+	}
+
+	bounds := newLengthBounds(cli)
+	sepLen := len(cli.Separator)
+	used := make([]uint64, groupCount(targetFile))
+	var spaces []*probabilitySpace
+	// a space of k target dimensions, followed by the wordlist dimension when words is set
+	addSpace := func(k int, words *weightedWords) {
+		space := &probabilitySpace{order: len(spaces)}
+		for range k {
+			space.dims = append(space.dims, targets)
+		}
+		if words != nil {
+			space.dims = append(space.dims, words)
+		}
+		space.visit = func(index []int) {
+			// nodes that reuse a target are walked through but not written
+			if !distinctTargets(space.dims, index, used) {
+				return
+			}
+			combo := make([]string, 0, k)
+			length := 0
+			for _, i := range index[:k] {
+				combo = append(combo, targets.words[i])
+				length += len(targets.words[i])
+			}
+			if words == nil {
+				if bounds.allows(length + sepLen*max(k-1, 0)) {
+					writer.write(combo)
+				}
+				return
+			}
+			word := words.words[index[k]]
+			if !bounds.allows(length + len(word) + sepLen*k) {
+				return
+			}
+			for pos := 0; pos <= len(combo); pos++ {
+				newCombo := make([]string, len(combo)+1)
+				copy(newCombo, combo[:pos])
+				newCombo[pos] = word
+				copy(newCombo[pos+1:], combo[pos:])
+				writer.write(newCombo)
+			}
+		}
+		spaces = append(spaces, space)
+	}
+	for k := cli.MinTarget; k <= cli.MaxTarget; k++ {
+		if cli.SelfCombination {
+			addSpace(k, nil)
+		}
+		for _, words := range wordlists {
+			addSpace(k, words)
+		}
+	}
+	walkProbabilityOrder(spaces, make([]float64, len(spaces)), writer)
+}
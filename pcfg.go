@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// pcfgStructure is a base structure such as L6D4S1: runs of letters, digits and symbols
+type pcfgStructure struct {
+	segments []string // L6, D4, S1
+	weight   float64
+}
+
+var pcfgStructureRe = regexp.MustCompile(`^([LDS][0-9]+)+$`)
+var pcfgSegmentRe = regexp.MustCompile(`[LDS][0-9]+`)
+
+// pcfgClass is the character class of r: L for letters, D for digits and S for the rest
+func pcfgClass(r rune) byte {
+	switch {
+	case unicode.IsLetter(r):
+		return 'L'
+	case unicode.IsDigit(r):
+		return 'D'
+	}
+	return 'S'
+}
+
+// structureOf returns the base structure of word, Summer2024! is L6D4S1
+func structureOf(word string) string {
+	var b strings.Builder
+	var class byte
+	run := 0
+	for _, r := range word {
+		if c := pcfgClass(r); c != class {
+			if run > 0 {
+				fmt.Fprintf(&b, "%c%d", class, run)
+			}
+			class, run = c, 0
+		}
+		run++
+	}
+	if run > 0 {
+		fmt.Fprintf(&b, "%c%d", class, run)
+	}
+	return b.String()
+}
+
+// writeStructures counts the base structures of words and writes them most common first, in
+// the format of uniq -c
+func writeStructures(path string, words []string, counts []float64) error {
+	totals := make(map[string]float64)
+	for i, word := range words {
+		if word != "" {
+			totals[structureOf(word)] += counts[i]
+		}
+	}
+	structures := make([]string, 0, len(totals))
+	for s := range totals {
+		structures = append(structures, s)
+	}
+	sort.Slice(structures, func(a, b int) bool {
+		if totals[structures[a]] != totals[structures[b]] {
+			return totals[structures[a]] > totals[structures[b]]
+		}
+		return structures[a] < structures[b]
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, s := range structures {
+		if _, err := fmt.Fprintf(file, "%7d %s\n", int64(totals[s]), s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadStructures reads a structures file written by train --pcfg-file
+func loadStructures(path string) ([]pcfgStructure, error) {
+	lines, err := readWordlist(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading structures %s: %w", path, err)
+	}
+	var structures []pcfgStructure
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		s, count := splitCount(line)
+		s = strings.TrimSpace(s)
+		if !pcfgStructureRe.MatchString(s) {
+			return nil, fmt.Errorf("structures %s line %d: %q is not a structure like L6D4S1", path, i+1, s)
+		}
+		structures = append(structures, pcfgStructure{segments: pcfgSegmentRe.FindAllString(s, -1), weight: count})
+	}
+	return structures, nil
+}
+
+// pcfgTerminals are the words that can fill a run of segments, keyed by the structure of the run
+type pcfgTerminals struct {
+	targets map[string]*weightedWords
+	words   map[string]*weightedWords
+}
+
+func newPCFGTerminals(targetFile []targetWord, cli CLI) pcfgTerminals {
+	terminals := pcfgTerminals{targets: make(map[string]*weightedWords), words: make(map[string]*weightedWords)}
+	targets := make(map[string][]targetWord)
+	for _, t := range targetFile {
+		if t.word != "" {
+			targets[structureOf(t.word)] = append(targets[structureOf(t.word)], t)
+		}
+	}
+	for s, list := range targets {
+		terminals.targets[s] = newWeightedTargets(list)
+	}
+
+	words := make(map[string][]string)
+	weights := make(map[string][]float64)
+	for _, wordlist := range filterByValidWordlistTarget(cli.Wordlists, cli) {
+		list, listWeights, err := loadWeightedWordlist(wordlist, cli)
+		if err != nil {
+			failGeneration(fmt.Errorf("Error reading wordlist %s: %v", wordlist, err))
+		}
+		for i, word := range list {
+			if word != "" {
+				s := structureOf(word)
+				words[s] = append(words[s], word)
+				weights[s] = append(weights[s], listWeights[i])
+			}
+		}
+	}
+	for s, list := range words {
+		terminals.words[s] = newWeightedWords(list, weights[s])
+	}
+	return terminals
+}
+
+// pcfgSpaces splits every structure into runs of segments, each filled by target words or by
+// wordlist words, with min to max runs of target words. Every way to fill a structure is a
+// space of its own, with the probability of the structure as its base.
+func pcfgSpaces(structures []pcfgStructure, terminals pcfgTerminals, minTargets, maxTargets int) ([][]*weightedWords, []float64) {
+	var total float64
+	for _, s := range structures {
+		total += s.weight
+	}
+	var spaces [][]*weightedWords
+	var bases []float64
+	for _, s := range structures {
+		base := math.Log(s.weight / total)
+		var dims []*weightedWords
+		var split func(pos, targetRuns int)
+		split = func(pos, targetRuns int) {
+			if pos == len(s.segments) {
+				if targetRuns >= minTargets {
+					spaces = append(spaces, append([]*weightedWords{}, dims...))
+					bases = append(bases, base)
+				}
+				return
+			}
+			for end := pos + 1; end <= len(s.segments); end++ {
+				run := strings.Join(s.segments[pos:end], "")
+				if list, ok := terminals.targets[run]; ok && targetRuns < maxTargets {
+					dims = append(dims, list)
+					split(end, targetRuns+1)
+					dims = dims[:len(dims)-1]
+				}
+				if list, ok := terminals.words[run]; ok {
+					dims = append(dims, list)
+					split(end, targetRuns)
+					dims = dims[:len(dims)-1]
+				}
+			}
+		}
+		split(0, 0)
+	}
+	return spaces, bases
+}
+
+// pcfgSpaceKeyspace counts the candidates of a space that fit in bounds, with the target words
+// of one group only combined when their masks do not overlap
+func pcfgSpaceKeyspace(dims []*weightedWords, bounds lengthBounds) uint64 {
+	limit := bounds.bucketLimit()
+	counts := make([]uint64, limit+1)
+	counts[0] = 1
+	var targetDims []*weightedWords
+	for _, dim := range dims {
+		if dim.targets != nil {
+			targetDims = append(targetDims, dim)
+			continue
+		}
+		choices := make([]uint64, limit+1)
+		for _, word := range dim.words {
+			choices[min(len(word), limit)]++
+		}
+		counts = convolveLengths(counts, choices, limit)
+	}
+
+	// table[filled][l] counts fillings of a subset of the target dimensions by distinct groups
+	full := 1<<len(targetDims) - 1
+	table := make([][]uint64, full+1)
+	for s := range table {
+		table[s] = make([]uint64, limit+1)
+	}
+	table[0][0] = 1
+	var all []targetWord
+	for _, dim := range targetDims {
+		all = append(all, dim.targets...)
+	}
+	for _, group := range groupTargetWords(all) {
+		// ways[filled][l] are the fillings of a subset of dimensions by words of this group
+		ways := make([][]uint64, full+1)
+		for s := range ways {
+			ways[s] = make([]uint64, limit+1)
+		}
+		var fill func(j, filled int, mask uint64, length int)
+		fill = func(j, filled int, mask uint64, length int) {
+			if j == len(targetDims) {
+				ways[filled][min(length, limit)]++
+				return
+			}
+			fill(j+1, filled, mask, length)
+			for _, t := range targetDims[j].targets {
+				if t.group == group[0].group && mask&t.mask == 0 {
+					fill(j+1, filled|1<<j, mask|t.mask, length+len(t.word))
+				}
+			}
+		}
+		fill(0, 0, 0, 0)
+
+		next := make([][]uint64, full+1)
+		for s := range table {
+			next[s] = append([]uint64{}, table[s]...)
+		}
+		for s := range table {
+			for sub := 1; sub <= full; sub++ {
+				if s&sub == 0 {
+					added := convolveLengths(table[s], ways[sub], limit)
+					for l, n := range added {
+						next[s|sub][l] += n
+					}
+				}
+			}
+		}
+		table = next
+	}
+	return sumLengthCounts(convolveLengths(counts, table[full], limit), bounds, 0)
+}
+
+// pcfgKeyspace is calculateKeyspace for --pcfg
+func pcfgKeyspace(targetFile []targetWord, cli CLI) uint64 {
+	structures, err := loadStructures(cli.PCFG)
+	if err != nil {
+		failGeneration(err)
+	}
+	spaces, _ := pcfgSpaces(structures, newPCFGTerminals(targetFile, cli), cli.MinTarget, cli.MaxTarget)
+	bounds := newLengthBounds(cli)
+	var total uint64
+	for _, dims := range spaces {
+		total += pcfgSpaceKeyspace(dims, bounds)
+	}
+	return total * uint64(len(cli.JoinStyle))
+}
+
+// processPCFG fills the base structures of --pcfg with target and wordlist words whose own
+// structure matches a run of segments, most probable first. The probability of a candidate is
+// that of its structure times the probabilities of its words within their list.
+func processPCFG(targetFile []targetWord, cli CLI, writer *candidateWriter) {
+	structures, err := loadStructures(cli.PCFG)
+	if err != nil {
+		failGeneration(err)
+	}
+	dimsOf, bases := pcfgSpaces(structures, newPCFGTerminals(targetFile, cli), cli.MinTarget, cli.MaxTarget)
+	if cli.Debug {
+		log.Printf("Loaded %d structures, filled in %d ways", len(structures), len(dimsOf))
+	}
+
+	bounds := newLengthBounds(cli)
+	used := make([]uint64, groupCount(targetFile))
+	spaces := make([]*probabilitySpace, len(dimsOf))
+	for s, dims := range dimsOf {
+		space := &probabilitySpace{order: s, dims: dims}
+		space.visit = func(index []int) {
+			if !distinctTargets(dims, index, used) {
+				return
+			}
+			elements := make([]string, len(dims))
+			length := 0
+			for j, i := range index {
+				elements[j] = dims[j].words[i]
+				length += len(elements[j])
+			}
+			if bounds.allows(length) {
+				writer.writeJoined(elements, "")
+			}
+		}
+		spaces[s] = space
+	}
+	walkProbabilityOrder(spaces, bases, writer)
+}
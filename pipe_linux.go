@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// pipeUnread returns how many bytes written to pipe were not read yet
+func pipeUnread(pipe *os.File) int64 {
+	var n int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, pipe.Fd(), syscall.TIOCINQ, uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return 0
+	}
+	return int64(n)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// charClass is a set of character classes, combined with |
+type charClass uint8
+
+const (
+	classLower charClass = 1 << iota
+	classUpper
+	classDigit
+	classSymbol
+
+	classAlpha = classLower | classUpper
+)
+
+var charClassNames = map[string]charClass{
+	"lower":  classLower,
+	"upper":  classUpper,
+	"digit":  classDigit,
+	"symbol": classSymbol,
+	"alpha":  classAlpha,
+}
+
+// passwordPolicy describes the password rules of a target system. Candidates that the target
+// would refuse are not worth sending to hashcat.
+type passwordPolicy struct {
+	minLength  int         // minimum length in bytes
+	maxLength  int         // maximum length in bytes, 0 is unlimited
+	require    []charClass // every entry must be matched by at least one character
+	minClasses int         // minimum amount of distinct classes out of lower, upper, digit and symbol
+	banned     []string    // case-insensitive substrings, such as the username
+}
+
+// policyPresets holds the policies of common systems
+var policyPresets = map[string]passwordPolicy{
+	// Active Directory default domain policy with password complexity enabled
+	"windows": {minLength: 7, minClasses: 3},
+	// PCI DSS v4 requirement 8.3.6
+	"pci": {minLength: 12, require: []charClass{classAlpha, classDigit}},
+	// NIST SP 800-63B memorized secrets
+	"nist": {minLength: 8},
+	// The classic web application "one of everything" policy
+	"complex": {minLength: 8, require: []charClass{classLower, classUpper, classDigit, classSymbol}},
+}
+
+// newPolicy starts from a preset (or nothing when preset is empty) and adds the required
+// classes, minimum class count and banned substrings on top of it.
+func newPolicy(preset string, require []string, minClasses int, banned []string) (*passwordPolicy, error) {
+	var policy passwordPolicy
+	if preset != "" {
+		base, ok := policyPresets[strings.ToLower(preset)]
+		if !ok {
+			return nil, fmt.Errorf("unknown policy preset %q, choose from %s", preset, strings.Join(policyPresetNames(), ", "))
+		}
+		policy = base
+		policy.require = append([]charClass{}, base.require...)
+	}
+	for _, name := range require {
+		class, ok := charClassNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown character class %q, choose from lower, upper, digit, symbol, alpha", name)
+		}
+		policy.require = append(policy.require, class)
+	}
+	if minClasses > policy.minClasses {
+		policy.minClasses = minClasses
+	}
+	if policy.minClasses > 4 {
+		return nil, fmt.Errorf("a policy can not require more than 4 character classes (got %d)", policy.minClasses)
+	}
+	for _, ban := range banned {
+		if ban != "" {
+			policy.banned = append(policy.banned, strings.ToLower(ban))
+		}
+	}
+	return &policy, nil
+}
+
+func policyPresetNames() []string {
+	var names []string
+	for name := range policyPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// classesOf returns the classes present in candidate. Caseless letters belong to no class,
+// bytes that are not valid UTF-8 count as symbols.
+func classesOf(candidate string) charClass {
+	var classes charClass
+	for i := 0; i < len(candidate); {
+		r, size := utf8.DecodeRuneInString(candidate[i:])
+		i += size
+		switch {
+		case r == utf8.RuneError && size == 1:
+			classes |= classSymbol
+		case unicode.IsLower(r):
+			classes |= classLower
+		case unicode.IsUpper(r):
+			classes |= classUpper
+		case unicode.IsDigit(r):
+			classes |= classDigit
+		case !unicode.IsLetter(r):
+			classes |= classSymbol
+		}
+	}
+	return classes
+}
+
+// allows reports whether the target system would accept candidate
+func (p *passwordPolicy) allows(candidate string) bool {
+	if len(candidate) < p.minLength || (p.maxLength > 0 && len(candidate) > p.maxLength) {
+		return false
+	}
+
+	classes := classesOf(candidate)
+	for _, required := range p.require {
+		if classes&required == 0 {
+			return false
+		}
+	}
+	if p.minClasses > 0 {
+		count := 0
+		for c := classes; c != 0; c &= c - 1 {
+			count++
+		}
+		if count < p.minClasses {
+			return false
+		}
+	}
+
+	if len(p.banned) > 0 {
+		lower := strings.ToLower(candidate)
+		for _, ban := range p.banned {
+			if strings.Contains(lower, ban) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// policyFromCLI builds the policy requested on the command line, nil when there is none
+func policyFromCLI(cli CLI) (*passwordPolicy, error) {
+	if cli.Policy == "" && len(cli.PolicyRequire) == 0 && cli.PolicyMinClasses == 0 && len(cli.PolicyBan) == 0 {
+		return nil, nil
+	}
+	return newPolicy(cli.Policy, cli.PolicyRequire, cli.PolicyMinClasses, cli.PolicyBan)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // generateCombinations generates all possible combinations of words from the dictionary for length k
@@ -87,42 +89,72 @@ func generateCombinationsIter(dict []string, targetLength int) <-chan []string {
 	return ch
 }
 
-// Iterative generator for permutations
-func generatePermutationsIter(arr []string, length int) <-chan []string {
+// Iterative generator for permutations, branches whose joined length (sepLen bytes between
+// words) exceeds bounds are pruned as soon as the partial permutation is too long.
+// Words of the same group are only combined when their masks do not overlap.
+// The generator gives up when stop is closed, so a consumer stopping early does not leave it behind.
+func generatePermutationsIter(arr []targetWord, length int, bounds lengthBounds, sepLen int, stop <-chan struct{}) <-chan []string {
 	ch := make(chan []string, 100)
 	go func() {
 		defer close(ch)
-		if length == 0 {
-			return
-		}
 		n := len(arr)
 		if n < length {
 			return
 		}
+		if length == 0 {
+			if bounds.allows(0) {
+				send(ch, []string{}, stop)
+			}
+			return
+		}
 
-		used := make([]bool, n)
-		var backtrack func([]string)
-		backtrack = func(current []string) {
+		used := make([]uint64, groupCount(arr))
+		var backtrack func([]string, int) bool
+		backtrack = func(current []string, size int) bool {
 			if len(current) == length {
+				if !bounds.allows(size) {
+					return true
+				}
 				tmp := make([]string, length)
 				copy(tmp, current)
-				ch <- tmp
-				return
+				return send(ch, tmp, stop)
 			}
 
 			for i := 0; i < n; i++ {
-				if !used[i] {
-					used[i] = true
-					backtrack(append(current, arr[i]))
-					used[i] = false
+				t := arr[i]
+				if used[t.group]&t.mask == 0 {
+					next := size + len(t.word)
+					if len(current) > 0 {
+						next += sepLen
+					}
+					if bounds.exceeded(next) {
+						continue
+					}
+					used[t.group] |= t.mask
+					ok := backtrack(append(current, t.word), next)
+					used[t.group] &^= t.mask
+					if !ok {
+						return false
+					}
 				}
 			}
+			return true
 		}
-		backtrack([]string{})
+		backtrack([]string{}, 0)
 	}()
 	return ch
 }
 
+// send sends combo on ch, it returns false instead when stop is closed first
+func send(ch chan<- []string, combo []string, stop <-chan struct{}) bool {
+	select {
+	case ch <- combo:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
 // Remove duplicate strings
 func removeDuplicates(slice []string) []string {
 	seen := make(map[string]bool)
@@ -151,19 +183,16 @@ func removeStringsPresentIn(slice, toRemove []string) []string {
 	return result
 }
 
-// Iterative generator for ruled combinations
-func generateRuledCombinationsIter(dict []string, ruledDict []string, targetLength int) <-chan []string {
+// Iterative generator for ruled combinations, limited to combos whose joined length fits in bounds
+func generateRuledCombinationsIter(dict []targetWord, ruledDict []string, targetLength int, bounds lengthBounds, sepLen int, stop <-chan struct{}) <-chan []string {
 	ch := make(chan []string, 100)
 	go func() {
 		defer close(ch)
-		dict = removeDuplicates(dict)
-		ruledDict = removeDuplicates(ruledDict)
-		ruledDict = removeStringsPresentIn(ruledDict, dict)
-
-		A := dict
-		B := ruledDict
+		A, B := ruledTargetSet(dict, ruledDict)
 		nA := len(A)
 		nB := len(B)
+		// separators are accounted for up front so both halves can be bounded on word bytes alone
+		wordBounds := bounds.shrink(sepLen * (targetLength - 1))
 
 		for k := 1; k <= targetLength && k <= nB; k++ {
 			if targetLength-k > nA {
@@ -172,8 +201,12 @@ func generateRuledCombinationsIter(dict []string, ruledDict []string, targetLeng
 
 			positionCombs := generatePositionCombinations(targetLength, k)
 			for _, posSet := range positionCombs {
-				for permB := range generatePermutationsIter(B, k) {
-					for permA := range generatePermutationsIter(A, targetLength-k) {
+				for permB := range generatePermutationsIter(B, k, wordBounds.upperOnly(), 0, stop) {
+					sizeB := 0
+					for _, word := range permB {
+						sizeB += len(word)
+					}
+					for permA := range generatePermutationsIter(A, targetLength-k, wordBounds.shrink(sizeB), 0, stop) {
 						comb := make([]string, targetLength)
 						for idx, pos := range posSet.ruledPositions {
 							comb[pos] = permB[idx]
@@ -181,7 +214,9 @@ func generateRuledCombinationsIter(dict []string, ruledDict []string, targetLeng
 						for idx, pos := range posSet.unruledPositions {
 							comb[pos] = permA[idx]
 						}
-						ch <- comb
+						if !send(ch, comb, stop) {
+							return
+						}
 					}
 				}
 			}
@@ -336,103 +371,169 @@ func generateRuledCombinations(dict []string, ruledDict []string, targetLength i
 	return res
 }
 
-func processAllWordlists(targetFile []string, ruledFile []string, cli CLI) {
+func processAllWordlists(targetFile []targetWord, ruledFile []string, cli CLI, writer *candidateWriter) {
 	validWordlists := filterByValidWordlistTarget(cli.Wordlists, cli)
 	if cli.Debug {
 		log.Printf("Loaded %d wordlists", len(validWordlists))
 	}
-
-	// Setup output writer
-	writer := createOutputWriter(cli)
-	defer writer.Flush()
+	counter := newComboCounter(targetFile, ruledFile, cli.MaxTarget, newLengthBounds(cli), len(cli.Separator))
 
 	// Process each length in strict order
 	for length := cli.MinTarget; length <= cli.MaxTarget; length++ {
+		if writer.done() {
+			return
+		}
 		if cli.Debug {
 			log.Printf("Processing length %d", length)
 		}
+		writer.stage.Length = length
 
 		// Process self-combinations first
 		if cli.SelfCombination {
-			processLength(targetFile, ruledFile, length, "", cli, writer)
+			writer.stage.Wordlist = ""
+			processLength(targetFile, ruledFile, length, "", counter, cli, writer)
 		}
 
 		// Process each wordlist for this length
 		for _, wordlist := range validWordlists {
+			if writer.done() {
+				return
+			}
 			if cli.Debug {
 				log.Printf("Processing %s at length %d", wordlist, length)
 			}
-			processLength(targetFile, ruledFile, length, wordlist, cli, writer)
+			writer.stage.Wordlist = wordlist
+			processLength(targetFile, ruledFile, length, wordlist, counter, cli, writer)
 		}
 	}
 }
 
 func processLength(
-	targetFile, ruledFile []string,
+	targetFile []targetWord,
+	ruledFile []string,
 	length int,
 	wordlist string,
+	counter *comboCounter,
 	cli CLI,
-	writer *bufio.Writer,
+	writer *candidateWriter,
 ) {
-	// Create fresh generator for this length
-	var generator <-chan []string
-	if len(ruledFile) > 0 {
-		generator = generateRuledCombinationsIter(targetFile, ruledFile, length)
-	} else {
-		generator = generateCombinationsIter(targetFile, length)
+	bounds := newLengthBounds(cli)
+	sepLen := len(cli.Separator)
+	stop := make(chan struct{})
+	defer close(stop)
+	newGenerator := func(bounds lengthBounds) <-chan []string {
+		if len(ruledFile) > 0 {
+			return generateRuledCombinationsIter(targetFile, ruledFile, length, bounds, sepLen, stop)
+		}
+		return generatePermutationsIter(targetFile, length, bounds, sepLen, stop)
 	}
 
 	if wordlist == "" {
+		writer.stage.Word, writer.stage.start = 0, writer.position
+		if writer.skipBlock(counter.count(length, bounds)) {
+			return
+		}
 		// Directly write combinations
-		for combo := range generator {
-			writer.WriteString(strings.Join(combo, cli.Separator))
-			writer.WriteByte('\n')
+		for combo := range newGenerator(bounds) {
+			if writer.done() {
+				return
+			}
+			writer.write(combo)
 		}
 		return
 	}
 
 	// Process wordlist with rules
-	words, err := readWordlist(wordlist)
+	processedWords, err := loadWordlistCandidates(wordlist, cli)
 	if err != nil {
-		log.Fatalf("Error reading wordlist %s: %v", wordlist, err)
+		failGeneration(fmt.Errorf("Error reading wordlist %s: %v", wordlist, err))
 	}
 
-	var processedWords []string
-	if cli.WordlistRules != "" {
-		rules, err := loadRulesFast(cli.WordlistRules)
-		if err != nil {
-			log.Fatal(err)
+	// Process each word with fresh generator
+	for i, word := range processedWords {
+		if writer.done() {
+			return
 		}
-		for _, rule := range rules {
-			processedWords = append(processedWords, applyRuleCPU(rule.RuleLine, words)...)
+		writer.stage.Word, writer.stage.start = i, writer.position
+		// The word and one separator are part of every candidate, leaving the rest to the combo
+		wordBounds := bounds.shrink(len(word) + sepLen)
+		if writer.skipBlock(counter.count(length, wordBounds) * uint64(length+1)) {
+			continue
 		}
-	} else {
-		processedWords = words
-	}
 
-	// Process each word with fresh generator
-	for _, word := range processedWords {
 		// Regenerate combinations for each word to ensure complete enumeration
-		if len(ruledFile) > 0 {
-			generator = generateRuledCombinationsIter(targetFile, ruledFile, length)
-		} else {
-			generator = generateCombinationsIter(targetFile, length)
-		}
-
-		for combo := range generator {
+		for combo := range newGenerator(wordBounds) {
 			// Generate all possible insertions of word into combo
 			for pos := 0; pos <= len(combo); pos++ {
+				if writer.done() {
+					return
+				}
 				newCombo := make([]string, len(combo)+1)
 				copy(newCombo, combo[:pos])
 				newCombo[pos] = word
 				copy(newCombo[pos+1:], combo[pos:])
-				writer.WriteString(strings.Join(newCombo, cli.Separator))
-				writer.WriteByte('\n')
+				writer.write(newCombo)
 			}
 		}
 	}
 }
 
+// loadWordlistCandidates reads a wordlist and expands it with the wordlist rules, rule by rule,
+// keeping the words allowed by the wordlist element filter
+func loadWordlistCandidates(wordlist string, cli CLI) ([]string, error) {
+	words, _, err := loadWeightedWordlist(wordlist, cli)
+	return words, err
+}
+
+// loadWeightedWordlist is loadWordlistCandidates with the weight of every word: the count of
+// its line with --counted, or else its rank in the file. Words made by a rule weigh as much
+// as the line they were made from.
+func loadWeightedWordlist(wordlist string, cli CLI) ([]string, []float64, error) {
+	words, err := readWordlist(wordlist)
+	if err != nil {
+		return nil, nil, err
+	}
+	weights := make([]float64, len(words))
+	for i, word := range words {
+		switch {
+		case cli.Counted:
+			words[i], weights[i] = splitCount(word)
+			words[i] = checkForHex(words[i])
+		case cli.WeighBy == weighRank:
+			weights[i] = rankWeight(i)
+		default:
+			weights[i] = 1
+		}
+	}
+
+	processedWords, processedWeights := words, weights
+	if cli.WordlistRules != "" {
+		rules, err := loadRulesFast(cli.WordlistRules)
+		if err != nil {
+			return nil, nil, err
+		}
+		processedWords, processedWeights = nil, nil
+		for _, rule := range rules {
+			processedWords = append(processedWords, applyRuleCPU(rule.RuleLine, words)...)
+			processedWeights = append(processedWeights, weights...)
+		}
+	}
+
+	filter := wordlistFilter(cli)
+	if filter == nil {
+		return processedWords, processedWeights, nil
+	}
+	var keptWords []string
+	var keptWeights []float64
+	for i, word := range processedWords {
+		if filter.allows(word) {
+			keptWords = append(keptWords, word)
+			keptWeights = append(keptWeights, processedWeights[i])
+		}
+	}
+	return keptWords, keptWeights, nil
+}
+
 func createOutputWriter(cli CLI) *bufio.Writer {
 	var output io.Writer = os.Stdout
 	if cli.OutputFile != "" {
@@ -445,6 +546,167 @@ func createOutputWriter(cli CLI) *bufio.Writer {
 	return bufio.NewWriterSize(output, 1<<20) // 1MB buffer
 }
 
+// yieldEvery is how many candidates the writer writes before letting other goroutines run
+const yieldEvery = 1 << 16
+
+// candidateWriter writes joined candidates and keeps track of the position in the keyspace,
+// so --skip and --limit line up with --keyspace. Every combo is written once per join style.
+type candidateWriter struct {
+	*bufio.Writer
+	separator string
+	styles    []string
+	policy    *passwordPolicy // candidates the policy refuses are counted but not written
+	filter    *regexFilter    // same for candidates refused by --include-regex and --exclude-regex
+	hexMode   string
+	ndjson    bool         // write lines of JSON with the keyspace index of every candidate
+	markov    *markovModel // candidates scoring below threshold are counted but not written,
+	threshold float64      // or with deferred written in a second pass, the low pass
+	deferred  bool
+	lowPass   bool
+	skip      uint64
+	limit     uint64
+	position  uint64 // keyspace index of the next candidate
+	written   uint64
+	// with a stride only candidates at index strideOffset, strideOffset+stride, ... are written
+	stride       uint64
+	strideOffset uint64
+	stage        generationStage
+	session      *session    // saved when checkpoint is set, nil without --session
+	checkpoint   atomic.Bool // set by the session timer
+	status       *statusReporter
+	consumer     *consumer   // nil without --exec
+	report       atomic.Bool // set by the status timer and SIGUSR1
+	interrupted  atomic.Bool // set on SIGINT and SIGTERM, stops the generation like a limit
+	expired      atomic.Bool // set when --runtime is over, same
+	released     atomic.Bool // set when the coordinator gave the chunk of a worker to another one, same
+}
+
+func newCandidateWriter(cli CLI, policy *passwordPolicy, filter *regexFilter, markov *markovModel) *candidateWriter {
+	if cli.Exec == "" {
+		return newCandidateWriterTo(createOutputWriter(cli), cli, policy, filter, markov)
+	}
+	c, err := startConsumer(cli.Exec, cli.Skip)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := newCandidateWriterTo(bufio.NewWriterSize(c, 1<<20), cli, policy, filter, markov)
+	w.consumer = c
+	return w
+}
+
+// newCandidateWriterTo is newCandidateWriter writing to output
+func newCandidateWriterTo(output *bufio.Writer, cli CLI, policy *passwordPolicy, filter *regexFilter, markov *markovModel) *candidateWriter {
+	return &candidateWriter{
+		Writer:    output,
+		separator: cli.Separator,
+		styles:    cli.JoinStyle,
+		policy:    policy,
+		filter:    filter,
+		hexMode:   cli.OutputHex,
+		markov:    markov,
+		threshold: cli.MarkovThreshold,
+		deferred:  markov != nil && cli.MarkovDefer,
+		skip:      cli.Skip,
+		limit:     cli.Limit,
+		stage:     generationStage{Rule: -1},
+	}
+}
+
+// skipBlock moves past the candidates of n combos at once if all of them fall inside --skip.
+// Deferred candidates are spread over two passes, so their blocks can not be skipped blind.
+func (w *candidateWriter) skipBlock(n uint64) bool {
+	if w.deferred {
+		return false
+	}
+	n *= uint64(len(w.styles))
+	if w.position+n > w.skip {
+		return false
+	}
+	w.position += n
+	return true
+}
+
+// done reports whether --limit candidates have been written
+func (w *candidateWriter) done() bool {
+	return w.stopped() || w.limit > 0 && w.position >= w.skip+w.limit
+}
+
+// write joins and writes one combo in every join style, skipping candidates inside --skip
+func (w *candidateWriter) write(combo []string) {
+	w.writeJoined(combo, w.separator)
+}
+
+// writeJoined is write with a separator of its own, templates place their separators themselves
+func (w *candidateWriter) writeJoined(elements []string, sep string) {
+	for _, style := range w.styles {
+		if w.done() {
+			return
+		}
+		if w.position%yieldEvery == 0 {
+			// the generator goroutines hand over to each other, leaving the signal and timer
+			// goroutines waiting on a single core unless the writer yields
+			runtime.Gosched()
+		}
+		if w.checkpoint.Load() {
+			w.saveSession()
+		}
+		if w.report.Load() {
+			w.reportStatus(stateRunning)
+		}
+		candidate := joinStyled(elements, sep, style)
+		if w.deferred && (w.markov.score(candidate) < w.threshold) != w.lowPass {
+			continue
+		}
+		w.position++
+		if w.position <= w.skip {
+			continue
+		}
+		if w.stride > 1 && (w.position-1)%w.stride != w.strideOffset {
+			continue
+		}
+		w.emit(candidate)
+	}
+}
+
+// emit applies the output filters and encoding to a candidate past --skip
+func (w *candidateWriter) emit(candidate string) {
+	if w.policy != nil && !w.policy.allows(candidate) {
+		return
+	}
+	if !w.filter.allows(candidate) {
+		return
+	}
+	if w.markov != nil && !w.deferred && w.markov.score(candidate) < w.threshold {
+		return
+	}
+	line := encodeHex(candidate, w.hexMode)
+	if w.ndjson {
+		encoded, _ := json.Marshal(ndjsonCandidate{Index: w.position - 1, Candidate: line})
+		line = string(encoded)
+	}
+	w.WriteString(line)
+	w.WriteByte('\n')
+	w.written++
+	if w.consumer != nil {
+		w.consumer.mark(len(line)+1, w.position)
+	}
+}
+
+// stopped reports whether a signal, --runtime or the exit of the --exec consumer stopped the
+// generation
+func (w *candidateWriter) stopped() bool {
+	return w.interrupted.Load() || w.expired.Load() || w.released.Load() || w.consumer.gone()
+}
+
+// deliveredPosition returns the position after the last candidate that made it out: to the
+// output, or with --exec to the consumer
+func (w *candidateWriter) deliveredPosition() uint64 {
+	if w.consumer != nil {
+		return w.consumer.acceptedPosition()
+	}
+	return w.position
+}
+
 // END AI
 // END AI
 // END AI
@@ -464,131 +726,92 @@ func removeMatchingWords(targetDictWithRule, targetFile []string) []string {
 	return result
 }
 
-func calculateKeyspace(targetWordlist []string, cli CLI) uint64 {
-	n := len(targetWordlist)
-	min := cli.MinTarget
-	max := cli.MaxTarget
-	var total uint64
-
-	permutations := func(n, k int) uint64 {
-		if k > n || n < 0 || k < 0 {
-			return 0
-		}
-		result := uint64(1)
-		for i := 0; i < k; i++ {
-			result *= uint64(n - i)
-		}
-		return result
+// ruledTargetWords applies one target rule and prepares its output for processAllWordlists
+func ruledTargetWords(ro *ruleObj, targetFile []targetWord, filter *regexFilter, cli CLI) []string {
+	newWords := applyRuleCPU(ro.RuleLine, wordsOf(targetFile))
+	if cli.PartialDeduplicate {
+		newWords = removeMatchingWords(newWords, wordsOf(targetFile))
 	}
+	return filter.apply(newWords)
+}
 
+func calculateKeyspace(targetWordlist []targetWord, cli CLI) uint64 {
 	validWordlists := filterByValidWordlistTarget(cli.Wordlists, cli)
 
-	if cli.TargetRules != "" {
-		targetRuleFile, err := loadRulesFast(cli.TargetRules)
-		if err != nil {
-			log.Fatalf("loading target rules: %v", err)
-		}
+	if cli.TargetRules == "" {
+		return passKeyspace(targetWordlist, []string{}, validWordlists, cli) * uint64(len(cli.JoinStyle))
+	}
 
-		for _, ro := range targetRuleFile {
-			if len(ro.RuleLine) == 1 && ro.RuleLine[0].Function == ":" {
-				continue
-			}
+	targetRuleFile, err := loadRulesFast(cli.TargetRules)
+	if err != nil {
+		failGeneration(fmt.Errorf("loading target rules: %v", err))
+	}
 
-			newWords := applyRuleCPU(ro.RuleLine, targetWordlist)
-			m := len(newWords)
-			baseSetSize := n + m
+	// mirror the target rule loop in main, every rule is a separate pass
+	elementFilter := targetFilter(cli)
+	var total uint64
+	for _, ro := range targetRuleFile {
+		if len(ro.RuleLine) == 1 && ro.RuleLine[0].Function == ":" {
+			continue
+		}
 
-			if cli.SelfCombination {
-				for i := min; i <= max; i++ {
-					total += permutations(baseSetSize, i)
-				}
-			}
+		newWords := ruledTargetWords(ro, targetWordlist, elementFilter, cli)
+		if len(newWords) > 0 {
+			total += passKeyspace(targetWordlist, newWords, validWordlists, cli)
+		}
+	}
+	return total * uint64(len(cli.JoinStyle))
+}
 
-			if len(validWordlists) > 0 {
-				var T uint64
-				for i := min; i <= max; i++ {
-					perm := permutations(baseSetSize, i)
-					T += perm * uint64(i+1)
-				}
+// passKeyspace counts the candidates a single processAllWordlists call generates
+func passKeyspace(targetFile []targetWord, ruledFile []string, validWordlists []string, cli CLI) uint64 {
+	bounds := newLengthBounds(cli)
+	sepLen := len(cli.Separator)
+	counter := newComboCounter(targetFile, ruledFile, cli.MaxTarget, bounds, sepLen)
+	var total uint64
 
-				for _, wordlist := range validWordlists {
-					if cli.WordlistRules != "" {
-						rules, err := loadRulesFast(cli.WordlistRules)
-						if err != nil {
-							log.Fatalf("loading wordlist rules: %v", err)
-						}
+	if cli.SelfCombination {
+		for i := cli.MinTarget; i <= cli.MaxTarget; i++ {
+			total += counter.count(i, bounds)
+		}
+	}
 
-						words, err := readWordlist(wordlist)
-						if err != nil {
-							log.Fatalf("reading wordlist %q: %v", wordlist, err)
-						}
+	// T is the amount of candidates per wordlist word when there are no length bounds
+	var T uint64
+	for i := cli.MinTarget; i <= cli.MaxTarget; i++ {
+		T += counter.count(i, bounds) * uint64(i+1)
+	}
 
-						var totalWords uint64
-						for _, rule := range rules {
-							filtered := applyRuleCPU(rule.RuleLine, words)
-							totalWords += uint64(len(filtered))
-						}
-						total += totalWords * T
-					} else {
-						count, err := countLines(wordlist)
-						if err != nil {
-							log.Fatalf("counting lines in %q: %v", wordlist, err)
-						}
-						total += uint64(count) * T
-					}
-				}
+	for _, wordlist := range validWordlists {
+		if cli.WordlistRules == "" && !bounds.active() && len(cli.WordlistIncludeRegex)+len(cli.WordlistExcludeRegex) == 0 {
+			count, err := countLines(wordlist)
+			if err != nil {
+				failGeneration(fmt.Errorf("counting lines in %q: %v", wordlist, err))
 			}
+			total += uint64(count) * T
+			continue
 		}
-	} else {
-		baseSetSize := n
 
-		if cli.SelfCombination {
-			for i := min; i <= max; i++ {
-				total += permutations(baseSetSize, i)
-			}
+		words, err := loadWordlistCandidates(wordlist, cli)
+		if err != nil {
+			failGeneration(fmt.Errorf("reading wordlist %q: %v", wordlist, err))
 		}
-
-		if len(validWordlists) > 0 {
-			var T uint64
-			for i := min; i <= max; i++ {
-				perm := permutations(baseSetSize, i)
-				T += perm * uint64(i+1)
-			}
-
-			for _, wordlist := range validWordlists {
-				if cli.WordlistRules != "" {
-					rules, err := loadRulesFast(cli.WordlistRules)
-					if err != nil {
-						log.Fatalf("loading wordlist rules: %v", err)
-					}
-
-					words, err := readWordlist(wordlist)
-					if err != nil {
-						log.Fatalf("reading wordlist %q: %v", wordlist, err)
-					}
-
-					var totalWords uint64
-					for _, rule := range rules {
-						filtered := applyRuleCPU(rule.RuleLine, words)
-						totalWords += uint64(len(filtered))
-					}
-					total += totalWords * T
-				} else {
-					count, err := countLines(wordlist)
-					if err != nil {
-						log.Fatalf("counting lines in %q: %v", wordlist, err)
-					}
-					total += uint64(count) * T
-				}
+		if !bounds.active() {
+			total += uint64(len(words)) * T
+			continue
+		}
+		for _, word := range words {
+			wordBounds := bounds.shrink(len(word) + sepLen)
+			for i := cli.MinTarget; i <= cli.MaxTarget; i++ {
+				total += counter.count(i, wordBounds) * uint64(i+1)
 			}
 		}
 	}
-
 	return total
 }
 
 func readWordlist(filename string) ([]string, error) {
-	file, err := os.Open(filename)
+	file, err := openInput(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -613,7 +836,7 @@ func readWordlist(filename string) ([]string, error) {
 }
 
 func countLines(filename string) (int, error) {
-	file, err := os.Open(filename)
+	file, err := openInput(filename)
 	if err != nil {
 		return 0, err
 	}
@@ -650,7 +873,7 @@ func filterByValidWordlistTarget(wordlists []string, cli CLI) []string {
 					}
 					if !info.IsDir() {
 						//log.Printf("Loading %s", path)
-						validWordlists = append(validWordlists, path)
+						validWordlists = append(validWordlists, expandWordlistPath(path, cli)...)
 						loadedFiles += 1
 					}
 					return nil
@@ -661,6 +884,7 @@ func filterByValidWordlistTarget(wordlists []string, cli CLI) []string {
 			if debug {
 				log.Printf("Loaded %d files from %s recursively", loadedFiles, wordlist)
 			}
+			continue
 		}
 		if valid, fileErr := isReadable(wordlist); !valid || fileErr != nil {
 			if fileErr != nil {
@@ -674,11 +898,29 @@ func filterByValidWordlistTarget(wordlists []string, cli CLI) []string {
 			}
 			continue
 		}
-		validWordlists = append(validWordlists, wordlist)
+		validWordlists = append(validWordlists, expandWordlistPath(wordlist, cli)...)
 	}
 	return validWordlists
 }
 
+// expandWordlistPath returns the wordlists in a file, which is either the file itself or the
+// members of a zip or tar archive treated like files in a directory
+func expandWordlistPath(path string, cli CLI) []string {
+	kind := archiveKind(path)
+	if kind == "" {
+		return []string{path}
+	}
+	members, err := listArchiveMembers(path, kind, cli.ArchiveGlob)
+	if err != nil {
+		log.Printf("reading archive %s: %v. It will be skipped", path, err)
+		return nil
+	}
+	if cli.Debug {
+		log.Printf("Loaded %d members from %s", len(members), path)
+	}
+	return members
+}
+
 // loop through lines per file
 func processWordlist(combinations *[][]string, wordlist string, cli CLI, skipCounter uint64) {
 	// setup writer with a buffered channel (stdout or file)
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+)
+
+// drawSamples picks n distinct indexes below keyspace, uniformly at random and reproducible by
+// seed, in ascending order. It uses Floyd's algorithm, so only the picked indexes are stored.
+func drawSamples(n, keyspace, seed uint64) []uint64 {
+	if n >= keyspace {
+		n = keyspace
+	}
+	rng := rand.New(rand.NewPCG(seed, seed))
+	picked := make(map[uint64]bool, n)
+	samples := make([]uint64, 0, n)
+	for j := keyspace - n; j < keyspace; j++ {
+		t := rng.Uint64N(j + 1)
+		if picked[t] {
+			t = j
+		}
+		picked[t] = true
+		samples = append(samples, t)
+	}
+	slices.Sort(samples)
+	return samples
+}
+
+// orderedLengthTable turns a table of sets of r words into the ordered selections of r words
+func orderedLengthTable(subsets [][]uint64, r int) []uint64 {
+	var orderings uint64 = 1
+	for i := 2; i <= r; i++ {
+		orderings *= uint64(i)
+	}
+	counts := append([]uint64{}, subsets[r]...)
+	for l := range counts {
+		counts[l] *= orderings
+	}
+	return counts
+}
+
+// unrankPermutation returns the combo at index in the order of generatePermutationsIter, for k
+// words of arr followed by the selections counted by length in tail. fixed bytes are added to
+// every length before it is checked against bounds. It also returns the bytes of the combo and
+// the index left over for the tail.
+//
+// At every position the words are tried in order and skipped with the number of completions
+// they allow: the remaining sets are the product of a factor per group, so the product of all
+// other groups is taken from prefix and suffix products and only the factor of the group of
+// the word itself changes.
+func unrankPermutation(arr []targetWord, k int, tail []uint64, bounds lengthBounds, fixed int, index uint64) ([]string, int, uint64) {
+	limit := len(tail) - 1
+	groups := groupTargetWords(arr)
+	groupIndex := make(map[int]int, len(groups))
+	for i, group := range groups {
+		groupIndex[group[0].group] = i
+	}
+	used := make([]uint64, len(groups))
+	available := func(group []targetWord, mask uint64) []targetWord {
+		var words []targetWord
+		for _, t := range group {
+			if mask&t.mask == 0 {
+				words = append(words, t)
+			}
+		}
+		return words
+	}
+
+	combo := make([]string, 0, k)
+	size := 0
+	for p := 0; p < k; p++ {
+		r := k - p - 1
+		identity := newLengthTable(r, limit)
+		identity[0][0] = 1
+		prefix := make([][][]uint64, len(groups)+1)
+		suffix := make([][][]uint64, len(groups)+1)
+		prefix[0], suffix[len(groups)] = identity, identity
+		factors := make([][][]uint64, len(groups))
+		for i, group := range groups {
+			factors[i] = groupLengthTable(available(group, used[i]), r, limit)
+			prefix[i+1] = convolveLengthTables(prefix[i], factors[i], limit)
+		}
+		for i := len(groups) - 1; i >= 0; i-- {
+			suffix[i] = convolveLengthTables(factors[i], suffix[i+1], limit)
+		}
+
+		// completions only depend on the group, the mask it is left with and the word length
+		type completionKey struct {
+			group  int
+			mask   uint64
+			length int
+		}
+		completions := make(map[completionKey]uint64)
+		chosen := false
+		for _, t := range arr {
+			g := groupIndex[t.group]
+			if used[g]&t.mask != 0 {
+				continue
+			}
+			key := completionKey{g, used[g] | t.mask, len(t.word)}
+			count, ok := completions[key]
+			if !ok {
+				rest := convolveLengthTables(prefix[g], suffix[g+1], limit)
+				rest = convolveLengthTables(rest, groupLengthTable(available(groups[g], key.mask), r, limit), limit)
+				counts := convolveLengths(orderedLengthTable(rest, r), tail, limit)
+				count = sumLengthCounts(counts, bounds, fixed+size+len(t.word))
+				completions[key] = count
+			}
+			if index < count {
+				used[g] = key.mask
+				combo = append(combo, t.word)
+				size += len(t.word)
+				chosen = true
+				break
+			}
+			index -= count
+		}
+		if !chosen {
+			failGeneration(errors.New("Sample index is outside the keyspace"))
+		}
+	}
+	return combo, size, index
+}
+
+// unrankCombo returns the combo at index in the order of processLength's generator. limit is the
+// bucket limit of the unshrunk bounds, as bounds may have been shrunk for a wordlist word.
+func unrankCombo(targetFile []targetWord, ruledFile []string, k int, bounds lengthBounds, limit, sepLen int, index uint64) []string {
+	unit := make([]uint64, limit+1)
+	unit[0] = 1
+	if len(ruledFile) == 0 {
+		combo, _, _ := unrankPermutation(targetFile, k, unit, bounds, sepLen*(k-1), index)
+		return combo
+	}
+
+	// mirrors generateRuledCombinationsIter: per amount of ruled words, every placement of
+	// them, then the ruled words, then the target words
+	A, B := ruledTargetSet(targetFile, ruledFile)
+	wordBounds := bounds.shrink(sepLen * (k - 1))
+	for kk := 1; kk <= k && kk <= len(B); kk++ {
+		if k-kk > len(A) {
+			continue
+		}
+		tailA := permutationLengthCounts(A, k-kk, limit)
+		if k-kk == 0 {
+			tailA = unit
+		}
+		perPlacement := sumLengthCounts(convolveLengths(permutationLengthCounts(B, kk, limit), tailA, limit), wordBounds, 0)
+		placements := generatePositionCombinations(k, kk)
+		if block := perPlacement * uint64(len(placements)); index >= block {
+			index -= block
+			continue
+		}
+		posSet := placements[index/perPlacement]
+		permB, sizeB, rest := unrankPermutation(B, kk, tailA, wordBounds, 0, index%perPlacement)
+		permA, _, _ := unrankPermutation(A, k-kk, unit, wordBounds, sizeB, rest)
+		comb := make([]string, k)
+		for idx, pos := range posSet.ruledPositions {
+			comb[pos] = permB[idx]
+		}
+		for idx, pos := range posSet.unruledPositions {
+			comb[pos] = permA[idx]
+		}
+		return comb
+	}
+	failGeneration(errors.New("Sample index is outside the keyspace"))
+	return nil
+}
+
+// sampler walks the blocks of the output in the order of processAllWordlists and writes the
+// sampled candidates of a block without generating anything else
+type sampler struct {
+	samples  []uint64 // write indexes, each combo write is one index for all join styles
+	styles   []uint64 // join style of every sample
+	position uint64
+	writer   *candidateWriter
+}
+
+// block handles the next n writes, write is called with the offset of every sample among them
+func (s *sampler) block(n uint64, write func(offset uint64) []string) {
+	for len(s.samples) > 0 && s.samples[0] < s.position+n && !s.writer.done() {
+		combo := write(s.samples[0] - s.position)
+		s.writer.emit(joinStyled(combo, s.writer.separator, s.writer.styles[s.styles[0]]))
+		s.samples, s.styles = s.samples[1:], s.styles[1:]
+	}
+	s.position += n
+}
+
+// pass walks the output of one processAllWordlists call
+func (s *sampler) pass(targetFile []targetWord, ruledFile []string, cli CLI) {
+	validWordlists := filterByValidWordlistTarget(cli.Wordlists, cli)
+	bounds := newLengthBounds(cli)
+	limit := bounds.bucketLimit()
+	sepLen := len(cli.Separator)
+	counter := newComboCounter(targetFile, ruledFile, cli.MaxTarget, bounds, sepLen)
+	words := make(map[string][]string)
+	fast := cli.WordlistRules == "" && !bounds.active() && len(cli.WordlistIncludeRegex)+len(cli.WordlistExcludeRegex) == 0
+
+	for k := cli.MinTarget; k <= cli.MaxTarget && len(s.samples) > 0; k++ {
+		if cli.SelfCombination {
+			s.block(counter.count(k, bounds), func(offset uint64) []string {
+				return unrankCombo(targetFile, ruledFile, k, bounds, limit, sepLen, offset)
+			})
+		}
+		for _, wordlist := range validWordlists {
+			if fast {
+				// every word has the same amount of candidates, so unsampled wordlists are skipped whole
+				lines, err := countLines(wordlist)
+				if err != nil {
+					failGeneration(fmt.Errorf("counting lines in %q: %v", wordlist, err))
+				}
+				if n := counter.count(k, bounds) * uint64(k+1) * uint64(lines); len(s.samples) == 0 || s.samples[0] >= s.position+n {
+					s.position += n
+					continue
+				}
+			}
+			if words[wordlist] == nil {
+				list, err := loadWordlistCandidates(wordlist, cli)
+				if err != nil {
+					failGeneration(fmt.Errorf("Error reading wordlist %s: %v", wordlist, err))
+				}
+				words[wordlist] = list
+			}
+			for _, word := range words[wordlist] {
+				wordBounds := bounds.shrink(len(word) + sepLen)
+				s.block(counter.count(k, wordBounds)*uint64(k+1), func(offset uint64) []string {
+					combo := unrankCombo(targetFile, ruledFile, k, wordBounds, limit, sepLen, offset/uint64(k+1))
+					pos := int(offset % uint64(k+1))
+					return slices.Insert(combo, pos, word)
+				})
+			}
+		}
+	}
+}
+
+// processSample writes --sample candidates drawn from the whole keyspace, in keyspace order
+func processSample(targetFile []targetWord, elementFilter *regexFilter, cli CLI, writer *candidateWriter) {
+	keyspace := calculateKeyspace(targetFile, cli)
+	styles := uint64(len(cli.JoinStyle))
+	s := &sampler{writer: writer}
+	for _, index := range drawSamples(cli.Sample, keyspace, cli.Seed) {
+		s.samples = append(s.samples, index/styles)
+		s.styles = append(s.styles, index%styles)
+	}
+	if cli.Debug {
+		log.Printf("Sampling %d of %d candidates", len(s.samples), keyspace)
+	}
+
+	if cli.TargetRules == "" {
+		s.pass(targetFile, []string{}, cli)
+		return
+	}
+	targetRuleFile, err := loadRulesFast(cli.TargetRules)
+	if err != nil {
+		failGeneration(err)
+	}
+	for _, ro := range targetRuleFile {
+		if len(s.samples) == 0 {
+			break
+		}
+		if len(ro.RuleLine) == 1 && ro.RuleLine[0].Function == ":" {
+			continue
+		}
+		if newWords := ruledTargetWords(ro, targetFile, elementFilter, cli); len(newWords) > 0 {
+			s.pass(targetFile, newWords, cli)
+		}
+	}
+}
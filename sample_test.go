@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestSampleMatchesFullRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"targets.txt":  "ab\nJohn Smith\nfghi\n1990-05-12\nklmno\n",
+		"targets.yaml": templateTargets,
+		"wordlist.txt": "1\n22\n333\n",
+		"rules.rule":   ":\nu\n$1\n$2 $3\n",
+	})
+	for _, tt := range []struct {
+		name    string
+		targets string
+		options []string
+	}{
+		{"plain", "targets.txt", nil},
+		{"separator", "targets.txt", []string{"-s", "_"}},
+		{"length bounds", "targets.txt", []string{"--min-length", "9", "--max-length", "14"}},
+		{"target rules", "targets.txt", []string{"-t", "rules.rule"}},
+		{"target rules and bounds", "targets.txt", []string{"-t", "rules.rule", "-s", "_", "--min-length", "8", "--max-length", "13"}},
+		{"wordlist rules", "targets.txt", []string{"-r", "rules.rule", "--max-length", "12"}},
+		{"aliases", "targets.yaml", []string{"-s", "."}},
+		{"dates", "targets.txt", []string{"--expand-dates", "--date-formats", "YYYY,YY,DDMM", "--max-length", "12"}},
+		{"tokens", "targets.txt", []string{"--tokenize", "--max-length", "14"}},
+		{"case variants", "targets.txt", []string{"--case-permute", "ends", "--min-length", "10"}},
+		{"join styles", "targets.txt", []string{"--join-style", "verbatim,camel,upper", "-s", "-"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{tt.targets, "wordlist.txt", "-x", "3"}, tt.options...)
+			full := lines(mustTarginator(t, dir, args...))
+			keyspace := keyspaceOfRun(t, dir, args...)
+			if keyspace != uint64(len(full)) {
+				t.Fatalf("keyspace %d, but %d candidates written", keyspace, len(full))
+			}
+
+			// sampling the whole keyspace unranks every index
+			all := append(slices.Clone(args), "--sample", strconv.FormatUint(keyspace, 10))
+			if got := lines(mustTarginator(t, dir, all...)); !slices.Equal(got, full) {
+				t.Errorf("sampling all %d indexes wrote %d candidates that differ from the full run", keyspace, len(got))
+			}
+
+			// the candidates of a seed are those at the drawn indexes of the full run
+			for _, seed := range []uint64{0, 7, 42} {
+				n := min(keyspace/3, 50)
+				var want []string
+				for _, index := range drawSamples(n, keyspace, seed) {
+					want = append(want, full[index])
+				}
+				sampled := append(slices.Clone(args), "--sample", strconv.FormatUint(n, 10), "--seed", strconv.FormatUint(seed, 10))
+				if got := lines(mustTarginator(t, dir, sampled...)); !slices.Equal(got, want) {
+					t.Errorf("seed %d: sampled %q, want %q", seed, got, want)
+				}
+			}
+		})
+	}
+}
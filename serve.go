@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+// ServeCLI holds the arguments of the serve command
+type ServeCLI struct {
+	Listen     string        `optional:"" help:"Address to listen on" default:"127.0.0.1:8080"`
+	Root       string        `optional:"" help:"Directory the server-side paths of jobs are resolved in, paths outside it are refused" default:"."`
+	JobTimeout time.Duration `optional:"" help:"Forget jobs that were not used for this long (0 keeps them until deleted)" default:"1h"`
+	Debug      bool          `optional:"" help:"Show Debug Messages" default:"false"`
+}
+
+// jobRequest is the body of POST /jobs
+type jobRequest struct {
+	Targets    []string `json:"targets"`     // lines of a target file
+	TargetFile string   `json:"target_file"` // or a target file on the server
+	Wordlists  []string `json:"wordlists"`   // wordlist files or directories on the server
+	Args       []string `json:"args"`        // other generate options, such as ["-x", "4", "-t", "best64.rule"]
+}
+
+// ndjsonCandidate is a line of a candidates stream with format=ndjson
+type ndjsonCandidate struct {
+	Index     uint64 `json:"index"`
+	Candidate string `json:"candidate"`
+}
+
+// job is a submitted generation, its candidates are streamed in ranges of the keyspace
+type job struct {
+	id       string
+	args     []string
+	gen      *generation
+	once     sync.Once
+	keyspace uint64
+	err      error // of calculating the keyspace
+
+	mu       sync.Mutex
+	streams  map[*candidateWriter]bool
+	lastUsed time.Time
+}
+
+// touch marks the job as used now
+func (j *job) touch() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.lastUsed = time.Now()
+}
+
+// idle tells how long the job was not used at now, a job streaming candidates is in use
+func (j *job) idle(now time.Time) time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.streams) > 0 {
+		return 0
+	}
+	return now.Sub(j.lastUsed)
+}
+
+// cancel stops every stream of the job
+func (j *job) cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for writer := range j.streams {
+		writer.interrupted.Store(true)
+	}
+}
+
+type server struct {
+	root       string
+	jobTimeout time.Duration
+	debug      bool
+	mu         sync.Mutex
+	jobs       map[string]*job
+}
+
+// runServe serves jobs over HTTP until the server fails
+func runServe(cmd ServeCLI) {
+	root, err := filepath.Abs(cmd.Root)
+	if err == nil {
+		// paths are checked against the root with its links followed, as they are
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &server{root: root, jobTimeout: cmd.JobTimeout, debug: cmd.Debug, jobs: make(map[string]*job)}
+	if s.jobTimeout > 0 {
+		go s.expireJobs()
+	}
+	log.Printf("Serving jobs on %s with paths in %s", cmd.Listen, root)
+	log.Fatal(http.ListenAndServe(cmd.Listen, s.handler()))
+}
+
+// handler routes the job endpoints
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.submit)
+	mux.HandleFunc("GET /jobs/{id}", s.info)
+	mux.HandleFunc("GET /jobs/{id}/keyspace", s.keyspace)
+	mux.HandleFunc("GET /jobs/{id}/candidates", s.candidates)
+	mux.HandleFunc("DELETE /jobs/{id}", s.cancel)
+	return mux
+}
+
+// expireJobs forgets the jobs that were not used within the job timeout
+func (s *server) expireJobs() {
+	for now := range time.Tick(max(s.jobTimeout/4, time.Second)) {
+		s.expire(now)
+	}
+}
+
+// expire forgets the jobs idle for the job timeout at now
+func (s *server) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, j := range s.jobs {
+		if j.idle(now) >= s.jobTimeout {
+			delete(s.jobs, id)
+			if s.debug {
+				log.Printf("Job %s: not used for %v, forgotten", id, s.jobTimeout)
+			}
+		}
+	}
+}
+
+// resolve returns path within the root of the server. Links are followed, a path leading
+// out of the root through one is refused like any other.
+func (s *server) resolve(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	resolved := filepath.Join(s.root, path)
+	if !s.within(resolved) {
+		return "", fmt.Errorf("path %s is outside of the server root", path)
+	}
+	// the member of an archive is resolved by its archive
+	member := ""
+	if archive, name, ok := splitArchivePath(resolved); ok {
+		resolved, member = archive, archiveSeparator+name
+	}
+	resolved, err := filepath.EvalSymlinks(resolved)
+	if err != nil {
+		return "", fmt.Errorf("path %s: %w", path, err)
+	}
+	if !s.within(resolved) {
+		return "", fmt.Errorf("path %s links outside of the server root", path)
+	}
+	// the files in a directory wordlist are read through their links too
+	if info, err := os.Stat(resolved); err == nil && info.IsDir() {
+		err := filepath.WalkDir(resolved, func(file string, d os.DirEntry, err error) error {
+			if err != nil || d.Type()&os.ModeSymlink == 0 {
+				return err
+			}
+			if target, err := filepath.EvalSymlinks(file); err == nil && !s.within(target) {
+				return fmt.Errorf("path %s links outside of the server root", file)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return resolved + member, nil
+}
+
+// within tells if a path with its links followed is in the root of the server
+func (s *server) within(path string) bool {
+	rel, err := filepath.Rel(s.root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// newJob parses and prepares a job request
+func (s *server) newJob(req jobRequest) (*job, error) {
+	target, err := s.resolve(req.TargetFile)
+	if err != nil {
+		return nil, err
+	}
+	if (target == "") == (req.Targets == nil) {
+		return nil, errors.New("a job needs either targets or a target_file")
+	}
+	if req.Targets != nil {
+		// the targets are loaded when the job is prepared, the file is not needed after that
+		file, err := os.CreateTemp("", "targinator-targets-*.txt")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(strings.Join(req.Targets, "\n") + "\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		target = file.Name()
+	}
+	var wordlists []string
+	for _, wordlist := range req.Wordlists {
+		resolved, err := s.resolve(wordlist)
+		if err != nil {
+			return nil, err
+		}
+		wordlists = append(wordlists, resolved)
+	}
+	args := append(append(append([]string{}, req.Args...), "--", target), wordlists...)
+
+	cli, err := parseJobArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	// positional arguments or a -- in args would bring in files outside of the root
+	if cli.Target != target || !slices.Equal(cli.Wordlists, wordlists) {
+		return nil, errors.New("args can not hold the target file or wordlists, use target_file and wordlists")
+	}
+	for _, path := range []*string{&cli.TargetRules, &cli.WordlistRules, &cli.Nicknames, &cli.LeetTable, &cli.Markov, &cli.PCFG} {
+		if *path, err = s.resolve(*path); err != nil {
+			return nil, err
+		}
+	}
+	for name, path := range cli.TemplateWordlist {
+		if cli.TemplateWordlist[name], err = s.resolve(path); err != nil {
+			return nil, err
+		}
+	}
+	cli.Debug = s.debug
+	gen, err := prepareGeneration(cli)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInputs(cli); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &job{id: hex.EncodeToString(id), args: req.Args, gen: gen, streams: make(map[*candidateWriter]bool), lastUsed: time.Now()}, nil
+}
+
+// parseJobArgs parses the generate arguments of a job, refusing the options of single runs:
+// where the candidates go, sessions, status and which part of the keyspace is written
+func parseJobArgs(args []string) (CLI, error) {
+	var cli CLI
+	parser, err := kong.New(&cli, kong.Name("targinator"), kong.NoDefaultHelp(), kong.Exit(func(int) {}))
+	if err != nil {
+		return cli, err
+	}
+	if _, err := parser.Parse(args); err != nil {
+		return cli, err
+	}
+	if cli.Exec != "" || cli.OutputFile != "" || cli.Session != "" || cli.Restore || cli.Status || cli.StatusJSON ||
+		cli.Runtime > 0 || cli.Keyspace || cli.Skip > 0 || cli.Limit > 0 || cli.Shard != "" || cli.Sample > 0 || cli.PartialDeduplicate {
+		return cli, errors.New("exec, output, session, restore, status, runtime, keyspace, skip, limit, shard, sample and partial deduplication options can not be used in jobs")
+	}
+	return cli, nil
+}
+
+// checkInputs reads the rules, templates and PCFG structures of cli, which the generation
+// expects to work once it runs
+func checkInputs(cli CLI) error {
+	for _, rules := range []string{cli.TargetRules, cli.WordlistRules} {
+		if rules != "" {
+			if _, err := loadRulesFast(rules); err != nil {
+				return err
+			}
+		}
+	}
+	if len(cli.Template) > 0 {
+		validWordlists := filterByValidWordlistTarget(cli.Wordlists, cli)
+		for _, pattern := range cli.Template {
+			if _, err := parseTemplate(pattern, cli, validWordlists); err != nil {
+				return err
+			}
+		}
+	}
+	if cli.PCFG != "" {
+		if _, err := loadStructures(cli.PCFG); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// job returns the job of the request, or writes a 404
+func (s *server) job(w http.ResponseWriter, r *http.Request) *job {
+	s.mu.Lock()
+	j := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if j == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return nil
+	}
+	j.touch()
+	return j
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// submit handles POST /jobs
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j, err := s.newJob(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.jobs[j.id] = j
+	s.mu.Unlock()
+	if s.debug {
+		log.Printf("Job %s: %d target words, args %q", j.id, len(j.gen.targets), j.args)
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": j.id})
+}
+
+// info handles GET /jobs/{id}
+func (s *server) info(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	streams := len(j.streams)
+	j.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"id": j.id, "args": j.args, "targets": len(j.gen.targets), "streams": streams})
+}
+
+// keyspace handles GET /jobs/{id}/keyspace, the keyspace is calculated once per job
+func (s *server) keyspace(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	j.once.Do(func() { j.keyspace, j.err = j.gen.keyspace() })
+	if j.err != nil {
+		http.Error(w, j.err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keyspace": j.keyspace})
+}
+
+// candidates handles GET /jobs/{id}/candidates?skip=S&limit=L&format=text|ndjson, streaming
+// the candidates at keyspace index S up to S+L. The Targinator-State trailer tells whether the
+// range was written completely (done), cut short by a cancel (interrupted) or by an error
+// (failed), which is in the Targinator-Error trailer.
+func (s *server) candidates(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	query := r.URL.Query()
+	cli := j.gen.cli
+	for name, value := range map[string]*uint64{"skip": &cli.Skip, "limit": &cli.Limit} {
+		if query.Has(name) {
+			n, err := strconv.ParseUint(query.Get(name), 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s %q", name, query.Get(name)), http.StatusBadRequest)
+				return
+			}
+			*value = n
+		}
+	}
+	format := query.Get("format")
+	switch format {
+	case "", "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		http.Error(w, "format must be text or ndjson", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Trailer", "Targinator-State, Targinator-Position, Targinator-Error")
+
+	writer := newCandidateWriterTo(bufio.NewWriterSize(w, 1<<16), cli, j.gen.policy, j.gen.outputFilter, j.gen.model)
+	writer.ndjson = format == "ndjson"
+	j.mu.Lock()
+	j.streams[writer] = true
+	j.mu.Unlock()
+	defer func() {
+		j.mu.Lock()
+		delete(j.streams, writer)
+		j.lastUsed = time.Now()
+		j.mu.Unlock()
+	}()
+	// a client hanging up stops the generation like a cancel
+	stop := context.AfterFunc(r.Context(), func() { writer.interrupted.Store(true) })
+	defer stop()
+
+	runErr := j.gen.run(writer)
+	writer.Flush()
+	state := stateDone
+	if runErr != nil {
+		state = stateFailed
+		w.Header().Set("Targinator-Error", runErr.Error())
+		log.Printf("Job %s: %v", j.id, runErr)
+	} else if writer.interrupted.Load() {
+		state = stateInterrupted
+	}
+	w.Header().Set("Targinator-State", state)
+	w.Header().Set("Targinator-Position", strconv.FormatUint(max(writer.position, cli.Skip), 10))
+	if s.debug {
+		log.Printf("Job %s: streamed %d candidates from %d, %s", j.id, writer.written, cli.Skip, state)
+	}
+}
+
+// cancel handles DELETE /jobs/{id}, stopping its streams and forgetting the job
+func (s *server) cancel(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.jobs, j.id)
+	s.mu.Unlock()
+	j.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
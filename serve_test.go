@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestServer is a server of the jobs in root
+func newTestServer(t *testing.T, root string, jobTimeout time.Duration) (*server, *httptest.Server) {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{root: root, jobTimeout: jobTimeout, jobs: make(map[string]*job)}
+	httpServer := httptest.NewServer(s.handler())
+	t.Cleanup(httpServer.Close)
+	return s, httpServer
+}
+
+// submitJob posts a job and returns its id, or the error message the server answered with
+func submitJob(t *testing.T, url string, req jobRequest) (id string, refused string) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url+"/jobs", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", string(data)
+	}
+	var answer struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &answer); err != nil {
+		t.Fatal(err)
+	}
+	return answer.ID, ""
+}
+
+func TestServeRefusesLinksOutOfRoot(t *testing.T) {
+	outside := writeFiles(t, map[string]string{"secret.txt": "hunter2\n"})
+	root := writeFiles(t, map[string]string{"wordlist.txt": numbered("", 3), "rules.rule": ":\n"})
+	for _, dir := range []string{"lists", "leaky"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"leak.txt":         filepath.Join(outside, "secret.txt"),
+		"out":              outside,
+		"leaky/secret.txt": filepath.Join(outside, "secret.txt"),
+		"alias.txt":        filepath.Join(root, "wordlist.txt"),
+		"lists/alias.txt":  filepath.Join(root, "wordlist.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("can not make links: %v", err)
+		}
+	}
+	_, httpServer := newTestServer(t, root, 0)
+
+	for name, req := range map[string]jobRequest{
+		"linked wordlist":          {Targets: []string{"word"}, Wordlists: []string{"leak.txt"}},
+		"linked target file":       {TargetFile: "leak.txt"},
+		"linked directory":         {Targets: []string{"word"}, Wordlists: []string{"out/secret.txt"}},
+		"link in a wordlist dir":   {Targets: []string{"word"}, Wordlists: []string{"leaky"}},
+		"linked rules":             {Targets: []string{"word"}, Args: []string{"-t", "leak.txt"}},
+		"parent directory":         {Targets: []string{"word"}, Wordlists: []string{"../secret.txt"}},
+		"wordlist that is missing": {Targets: []string{"word"}, Wordlists: []string{"missing.txt"}},
+	} {
+		if id, _ := submitJob(t, httpServer.URL, req); id != "" {
+			t.Errorf("%s: job %s was accepted", name, id)
+		}
+	}
+	for name, req := range map[string]jobRequest{
+		"wordlist":                {Targets: []string{"word"}, Wordlists: []string{"wordlist.txt"}, Args: []string{"-t", "rules.rule"}},
+		"link in the root":        {Targets: []string{"word"}, Wordlists: []string{"alias.txt"}},
+		"dir with a link in root": {Targets: []string{"word"}, Wordlists: []string{"lists"}},
+		"target file in a subdir": {TargetFile: "lists/alias.txt"},
+	} {
+		if _, refused := submitJob(t, httpServer.URL, req); refused != "" {
+			t.Errorf("%s: refused with %s", name, refused)
+		}
+	}
+}
+
+func TestServeExpiresIdleJobs(t *testing.T) {
+	root := writeFiles(t, map[string]string{"wordlist.txt": numbered("", 3)})
+	s, httpServer := newTestServer(t, root, time.Hour)
+	req := jobRequest{Targets: []string{"word"}, Wordlists: []string{"wordlist.txt"}}
+	idle, _ := submitJob(t, httpServer.URL, req)
+	used, _ := submitJob(t, httpServer.URL, req)
+	streaming, _ := submitJob(t, httpServer.URL, req)
+	if idle == "" || used == "" || streaming == "" {
+		t.Fatal("a job was refused")
+	}
+	start := time.Now()
+
+	s.jobs[used].lastUsed = start.Add(30 * time.Minute)
+	s.jobs[streaming].streams[&candidateWriter{}] = true
+	s.expire(start.Add(time.Hour))
+	for id, want := range map[string]bool{idle: false, used: true, streaming: true} {
+		if _, ok := s.jobs[id]; ok != want {
+			t.Errorf("job %s kept %v after an hour, want %v", id, ok, want)
+		}
+	}
+
+	resp, err := http.Get(httpServer.URL + "/jobs/" + idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expired job answered with %s", resp.Status)
+	}
+}
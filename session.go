@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// generationStage is where the nested generation loops are, for the session file
+type generationStage struct {
+	Rule     int    `json:"rule"` // index in --target-rules, -1 without target rules
+	Template string `json:"template,omitempty"`
+	Length   int    `json:"length,omitempty"`
+	Wordlist string `json:"wordlist,omitempty"` // empty during self-combinations
+	Word     int    `json:"word"`               // index of the word in the wordlist
+	Combo    uint64 `json:"combo"`              // candidates since the start of the word or length
+	start    uint64 // keyspace index of the first candidate of the word or length
+}
+
+// sessionState is the content of a session file
+type sessionState struct {
+	Command  string           `json:"command"`
+	Config   string           `json:"config"`          // hash of the configuration and the inputs
+	Position uint64           `json:"position"`        // keyspace index of the first candidate not written yet
+	Stage    *generationStage `json:"stage,omitempty"` // left out with --exec, see save
+	Saved    time.Time        `json:"saved"`
+}
+
+// session saves the position of a run so --restore can continue from it
+type session struct {
+	path   string
+	config string
+}
+
+// newSession returns the session of cli, stored as NAME.session
+func newSession(cli CLI) *session {
+	return &session{path: cli.Session + ".session", config: configHash(cli)}
+}
+
+// configHash hashes everything that changes the keyspace: the arguments, and the size and
+// modification time of the input files
+func configHash(cli CLI) string {
+	cli.Session, cli.Restore, cli.SessionInterval, cli.Debug = "", false, 0, false
+	cli.Status, cli.StatusInterval, cli.StatusJSON, cli.Runtime = false, 0, false, 0
+	// where the candidates go does not change them
+	cli.OutputFile, cli.Exec = "", ""
+	config, err := json.Marshal(cli)
+	if err != nil {
+		log.Fatal(err)
+	}
+	h := sha256.New()
+	h.Write(config)
+
+	inputs := []string{cli.Target, cli.TargetRules, cli.WordlistRules, cli.Nicknames, cli.LeetTable, cli.Markov, cli.PCFG}
+	inputs = append(inputs, cli.Wordlists...)
+	// in a fixed order, map iteration is random
+	for _, name := range slices.Sorted(maps.Keys(cli.TemplateWordlist)) {
+		inputs = append(inputs, cli.TemplateWordlist[name])
+	}
+	for _, input := range inputs {
+		if input == "" {
+			continue
+		}
+		// directories of wordlists count with every file in them
+		filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				fmt.Fprintf(h, "%s missing\n", path)
+				return nil
+			}
+			if info, err := d.Info(); err == nil && !d.IsDir() {
+				fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *session) load() (*sessionState, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var state sessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("reading session %s: %w", s.path, err)
+	}
+	return &state, nil
+}
+
+// save flushes the writer and stores its position. The file is replaced in one rename, so an
+// interrupted save leaves the previous one.
+func (s *session) save(writer *candidateWriter) error {
+	if err := writer.Flush(); err != nil && !writer.consumer.gone() {
+		return err
+	}
+	state := sessionState{
+		Command:  strings.Join(os.Args[1:], " "),
+		Config:   s.config,
+		Position: max(writer.deliveredPosition(), writer.skip), // skipped candidates were written by an earlier run
+		Saved:    time.Now(),
+	}
+	// the stage is where the generator is, a consumer may not have taken the candidates up to it
+	if writer.consumer == nil {
+		stage := writer.stage
+		stage.Combo = writer.position - stage.start
+		state.Stage = &stage
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp := s.path + ".tmp"
+	if err := os.WriteFile(temp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.path)
+}
+
+// saveSession saves the session of the writer, a failed save is logged and tried again at the
+// next checkpoint
+func (w *candidateWriter) saveSession() {
+	w.checkpoint.Store(false)
+	if w.session == nil {
+		return
+	}
+	if err := w.session.save(w); err != nil {
+		log.Printf("Saving session: %v", err)
+	}
+}
+
+// restore moves skip and limit of cli past the candidates the session already wrote. It returns
+// false when nothing is left to write.
+func (s *session) restore(cli *CLI) (bool, error) {
+	state, err := s.load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("no session to restore in %s", s.path)
+	} else if err != nil {
+		return false, err
+	}
+	if state.Config != s.config {
+		return false, fmt.Errorf("session %s was started with other arguments or inputs: %s", s.path, state.Command)
+	}
+	if state.Stage != nil {
+		log.Printf("Restoring session %s at candidate %d (length %d, wordlist %q, word %d, rule %d)",
+			s.path, state.Position, state.Stage.Length, state.Stage.Wordlist, state.Stage.Word, state.Stage.Rule)
+	} else {
+		log.Printf("Restoring session %s at candidate %d", s.path, state.Position)
+	}
+	if state.Position <= cli.Skip {
+		return true, nil
+	}
+	if cli.Limit > 0 {
+		end := cli.Skip + cli.Limit
+		if state.Position >= end {
+			return false, nil
+		}
+		cli.Limit = end - state.Position
+	}
+	cli.Skip = state.Position
+	return true, nil
+}
+
+// finish removes the session of a completed run
+func (s *session) finish() {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Println(err)
+	}
+}
+
+// watch has the writer save the session every interval
+func (s *session) watch(writer *candidateWriter, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			writer.checkpoint.Store(true)
+		}
+	}()
+}
+
+// handleSignals stops the writer on SIGINT and SIGTERM, so the output is flushed and the session
+// saved before exiting. A second signal exits right away.
+func handleSignals(writer *candidateWriter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		writer.interrupted.Store(true)
+		<-signals
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// shard modes: a contiguous range of the keyspace, or every n-th candidate
+const (
+	shardRange  = "range"
+	shardStride = "stride"
+)
+
+// shard is slice index (1 to count) of a keyspace split in count slices
+type shard struct {
+	index uint64
+	count uint64
+	mode  string
+}
+
+// parseShard reads a shard written as i/n, such as 2/4
+func parseShard(spec, mode string) (*shard, error) {
+	i, n, ok := strings.Cut(spec, "/")
+	index, err1 := strconv.ParseUint(i, 10, 64)
+	count, err2 := strconv.ParseUint(n, 10, 64)
+	if !ok || err1 != nil || err2 != nil || count == 0 || index < 1 || index > count {
+		return nil, fmt.Errorf("invalid shard %q, expected i/n with 1 <= i <= n such as 2/4", spec)
+	}
+	return &shard{index: index, count: count, mode: mode}, nil
+}
+
+// rangeOf returns the skip and limit of a range shard. Boundaries are at i*keyspace/n, so the
+// slices differ in size by one candidate at most.
+func (s *shard) rangeOf(keyspace uint64) (skip, limit uint64) {
+	boundary := func(i uint64) uint64 {
+		hi, lo := bits.Mul64(keyspace, i)
+		q, _ := bits.Div64(hi, lo, s.count)
+		return q
+	}
+	skip = boundary(s.index - 1)
+	return skip, boundary(s.index) - skip
+}
+
+// size returns the amount of candidates of the keyspace in this shard
+func (s *shard) size(keyspace uint64) uint64 {
+	if s.mode == shardStride {
+		if keyspace < s.index {
+			return 0
+		}
+		return (keyspace-s.index)/s.count + 1
+	}
+	_, limit := s.rangeOf(keyspace)
+	return limit
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestShardUnion(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"targets.txt":  numbered("word", 5),
+		"wordlist.txt": numbered("", 8),
+		"rules.rule":   ":\nu\n$1\nc $!\n",
+	})
+	base := []string{"targets.txt", "wordlist.txt", "-x", "3"}
+	for name, options := range map[string][]string{
+		"plain":          nil,
+		"target rules":   {"-t", "rules.rule"},
+		"wordlist rules": {"-r", "rules.rule"},
+		"length bounds":  {"--min-length", "9", "--max-length", "12"},
+		"all":            {"-t", "rules.rule", "-r", "rules.rule", "--min-length", "8", "--max-length", "13"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args := append(slices.Clone(base), options...)
+			full := lines(mustTarginator(t, dir, args...))
+			keyspace := keyspaceOfRun(t, dir, args...)
+			if keyspace != uint64(len(full)) {
+				t.Fatalf("keyspace %d, but %d candidates written", keyspace, len(full))
+			}
+			for n := 1; n <= 4; n++ {
+				for _, mode := range []string{shardRange, shardStride} {
+					var shards [][]string
+					var sizes uint64
+					for i := 1; i <= n; i++ {
+						shardArgs := append(slices.Clone(args), "--shard", fmt.Sprintf("%d/%d", i, n), "--shard-mode", mode)
+						shards = append(shards, lines(mustTarginator(t, dir, shardArgs...)))
+						sizes += keyspaceOfRun(t, dir, shardArgs...)
+					}
+					if sizes != keyspace {
+						t.Errorf("%s %d: shard keyspaces add up to %d, want %d", mode, n, sizes, keyspace)
+					}
+					var merged []string
+					if mode == shardRange {
+						merged = slices.Concat(shards...)
+					} else {
+						// candidate j of shard i is candidate (i-1)+j*n of the keyspace, the first shard is the longest
+						for j := range shards[0] {
+							for _, shard := range shards {
+								if j < len(shard) {
+									merged = append(merged, shard[j])
+								}
+							}
+						}
+					}
+					if !slices.Equal(merged, full) {
+						t.Errorf("%s %d: shards merge into %d candidates that differ from the %d unsharded ones", mode, n, len(merged), len(full))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestShardRangeOf(t *testing.T) {
+	for _, keyspace := range []uint64{0, 1, 7, 1 << 40, math.MaxUint64 / 3, math.MaxUint64} {
+		for _, count := range []uint64{1, 2, 3, 7, 1000} {
+			var next, largest, smallest uint64 = 0, 0, math.MaxUint64
+			for index := uint64(1); index <= count; index++ {
+				s := &shard{index: index, count: count, mode: shardRange}
+				skip, limit := s.rangeOf(keyspace)
+				if skip != next {
+					t.Fatalf("keyspace %d shard %d/%d starts at %d, want %d", keyspace, index, count, skip, next)
+				}
+				if size := s.size(keyspace); size != limit {
+					t.Errorf("keyspace %d shard %d/%d has size %d and limit %d", keyspace, index, count, size, limit)
+				}
+				next = skip + limit
+				largest, smallest = max(largest, limit), min(smallest, limit)
+			}
+			if next != keyspace {
+				t.Errorf("keyspace %d in %d shards ends at %d", keyspace, count, next)
+			}
+			if largest-smallest > 1 {
+				t.Errorf("keyspace %d in %d shards has sizes %d to %d", keyspace, count, smallest, largest)
+			}
+		}
+	}
+}
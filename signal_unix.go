@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleStatusSignal has the writer report its status on SIGUSR1
+func handleStatusSignal(writer *candidateWriter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			writer.report.Store(true)
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// handleStatusSignal does nothing, Windows has no SIGUSR1
+func handleStatusSignal(writer *candidateWriter) {}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// run states of a status report
+const (
+	stateRunning        = "running"
+	stateDone           = "done"
+	stateInterrupted    = "interrupted"
+	stateRuntime        = "runtime"
+	stateConsumerExited = "consumer-exited" // the --exec consumer stopped reading early
+	stateFailed         = "failed"          // the generation stopped on an error, such as a missing wordlist
+)
+
+// status is one progress report, written as a line of JSON with --status-json
+type status struct {
+	State    string  `json:"state"`
+	Stage    string  `json:"stage"`
+	Position uint64  `json:"position"`           // keyspace index of the next candidate
+	Keyspace uint64  `json:"keyspace,omitempty"` // end of the run in the keyspace, 0 when unknown
+	Percent  float64 `json:"percent"`
+	Written  uint64  `json:"written"` // candidates written, filtered ones are not
+	Rate     float64 `json:"rate"`    // keyspace candidates per second
+	Elapsed  float64 `json:"elapsed"` // seconds
+	ETA      float64 `json:"eta"`     // seconds, 0 when unknown
+}
+
+// statusReporter reports the progress of the writer on stderr
+type statusReporter struct {
+	json    bool
+	first   uint64 // the run covers keyspace indexes first to end
+	end     uint64
+	from    uint64 // position this process started generating at, after a restore
+	started time.Time
+}
+
+// newStatusReporter reports on a run from first to end of the keyspace, end is 0 when the
+// keyspace was not calculated
+func newStatusReporter(cli CLI, first, end uint64) *statusReporter {
+	return &statusReporter{json: cli.StatusJSON, first: first, end: end, from: cli.Skip, started: time.Now()}
+}
+
+// watch has the writer report every interval, an interval of 0 only reports on SIGUSR1
+func (r *statusReporter) watch(writer *candidateWriter, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			writer.report.Store(true)
+		}
+	}()
+}
+
+func (r *statusReporter) status(writer *candidateWriter, state string) status {
+	elapsed := time.Since(r.started).Seconds()
+	s := status{
+		State:    state,
+		Stage:    writer.describeStage(),
+		Position: writer.position,
+		Keyspace: r.end,
+		Written:  writer.written,
+		Elapsed:  elapsed,
+	}
+	if writer.position > r.from && elapsed > 0 {
+		s.Rate = float64(writer.position-r.from) / elapsed
+	}
+	if r.end > r.first {
+		s.Percent = 100 * float64(min(writer.position, r.end)-min(writer.position, r.first)) / float64(r.end-r.first)
+		if s.Rate > 0 && writer.position < r.end {
+			s.ETA = float64(r.end-writer.position) / s.Rate
+		}
+	}
+	if state == stateDone {
+		s.Percent, s.ETA = 100, 0
+	}
+	return s
+}
+
+func (r *statusReporter) write(s status) {
+	if r.json {
+		line, err := json.Marshal(s)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", line)
+		return
+	}
+	progress := fmt.Sprintf("%d", s.Position)
+	if s.Keyspace > 0 {
+		progress = fmt.Sprintf("%d/%d (%.2f%%)", s.Position, s.Keyspace, s.Percent)
+	}
+	line := fmt.Sprintf("Status %s: %s candidates, %d written, %.0f/s", s.State, progress, s.Written, s.Rate)
+	if s.ETA > 0 {
+		line += ", ETA " + (time.Duration(s.ETA) * time.Second).String()
+	}
+	if s.Stage != "" {
+		line += ", " + s.Stage
+	}
+	log.Println(line)
+}
+
+// reportStatus writes a status report of the writer
+func (w *candidateWriter) reportStatus(state string) {
+	w.report.Store(false)
+	w.status.write(w.status.status(w, state))
+}
+
+// describeStage describes where the generation is, such as length 3, wordlist rockyou.txt, word 12
+func (w *candidateWriter) describeStage() string {
+	var parts []string
+	if w.stage.Rule >= 0 {
+		parts = append(parts, fmt.Sprintf("rule %d", w.stage.Rule))
+	}
+	if w.stage.Template != "" {
+		parts = append(parts, "template "+w.stage.Template)
+	}
+	if w.stage.Length > 0 {
+		parts = append(parts, fmt.Sprintf("length %d", w.stage.Length))
+		if w.stage.Wordlist == "" {
+			parts = append(parts, "self-combinations")
+		} else {
+			parts = append(parts, "wordlist "+w.stage.Wordlist, fmt.Sprintf("word %d", w.stage.Word))
+		}
+	}
+	if w.lowPass {
+		parts = append(parts, "low pass")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// target categories of a structured target file
+const (
+	categoryName   = "name"
+	categoryDate   = "date"
+	categoryPlace  = "place"
+	categoryNumber = "number"
+	categoryWord   = "word"
+)
+
+var targetCategories = []string{categoryName, categoryDate, categoryPlace, categoryNumber, categoryWord}
+
+// targetEntry is a single target with everything known about it. Lines of a plain target
+// file become entries with only a Value.
+type targetEntry struct {
+	Value    string   `yaml:"value"`
+	Category string   `yaml:"category"` // empty when not declared
+	Weight   float64  `yaml:"weight"`
+	Aliases  []string `yaml:"aliases"`
+}
+
+// UnmarshalYAML also accepts a bare string as an entry without any extra information
+func (e *targetEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.Value = node.Value
+		return nil
+	}
+	type plain targetEntry
+	return node.Decode((*plain)(e))
+}
+
+// targetDocument is the layout of a structured target file:
+//
+//	targets:
+//	  - value: Robert
+//	    category: name
+//	    weight: 3
+//	    aliases: [Bob, Rob]
+//	  - value: 1990-05-12
+//	    category: date
+//	  - Florida
+type targetDocument struct {
+	Targets []targetEntry `yaml:"targets"`
+}
+
+// isStructuredTargetFile reports whether path is a YAML or JSON target file, judged by its
+// extension after any compression extension
+func isStructuredTargetFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".gz", ".bz2", ".zst", ".xz":
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path))))
+	}
+	return ext == ".yaml" || ext == ".yml" || ext == ".json"
+}
+
+// loadTargets loads a plain or structured target file. Lines of a counted plain file start
+// with their count, as written by uniq -c, which becomes their weight.
+func loadTargets(path string, counted bool) ([]targetEntry, error) {
+	if !isStructuredTargetFile(path) {
+		lines, err := loadTargetFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]targetEntry, len(lines))
+		for i, line := range lines {
+			entries[i] = targetEntry{Value: line, Weight: 1}
+			if counted {
+				entries[i].Value, entries[i].Weight = splitCount(line)
+				entries[i].Value = checkForHex(entries[i].Value)
+			}
+		}
+		return entries, nil
+	}
+
+	file, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening target file %s: %w", path, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading target file %s: %w", path, err)
+	}
+
+	// YAML is a superset of JSON, one decoder handles both
+	var doc targetDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing target file %s: %w", path, err)
+	}
+	for i := range doc.Targets {
+		entry := &doc.Targets[i]
+		entry.Value = checkForHex(entry.Value)
+		for j := range entry.Aliases {
+			entry.Aliases[j] = checkForHex(entry.Aliases[j])
+		}
+		entry.Category = strings.ToLower(entry.Category)
+		if entry.Category != "" && !isTargetCategory(entry.Category) {
+			return nil, fmt.Errorf("target %q in %s has unknown category %q, choose from %s",
+				entry.Value, path, entry.Category, strings.Join(targetCategories, ", "))
+		}
+		if entry.Weight < 0 {
+			return nil, fmt.Errorf("target %q in %s has a negative weight", entry.Value, path)
+		}
+		if entry.Weight == 0 {
+			entry.Weight = 1
+		}
+	}
+	return doc.Targets, nil
+}
+
+func isTargetCategory(category string) bool {
+	for _, c := range targetCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// category returns the declared category, undeclared targets are plain words
+func (e targetEntry) category() string {
+	if e.Category == "" {
+		return categoryWord
+	}
+	return e.Category
+}
+
+// targetWord is a single word that can be placed in a combination
+type targetWord struct {
+	word     string
+	category string
+	group    int     // words derived from the same target entry share a group
+	mask     uint64  // words of one group only combine when their masks do not overlap
+	weight   float64 // for --order probability
+}
+
+// newTargetWords gives every word its own group, numbered from firstGroup
+func newTargetWords(words []string, firstGroup int) []targetWord {
+	result := make([]targetWord, len(words))
+	for i, word := range words {
+		result[i] = targetWord{word: word, category: categoryWord, group: firstGroup + i, mask: 1, weight: 1}
+	}
+	return result
+}
+
+// wordsOf returns the plain words of a target set, for the rule engine
+func wordsOf(targets []targetWord) []string {
+	words := make([]string, len(targets))
+	for i, t := range targets {
+		words[i] = t.word
+	}
+	return words
+}
+
+// groupCount returns one more than the highest group in use
+func groupCount(targets []targetWord) int {
+	count := 0
+	for _, t := range targets {
+		count = max(count, t.group+1)
+	}
+	return count
+}
+
+// filterTargetWords keeps the target words allowed by the element filter
+func filterTargetWords(targets []targetWord, filter *regexFilter) []targetWord {
+	if filter == nil {
+		return targets
+	}
+	result := make([]targetWord, 0, len(targets))
+	for _, t := range targets {
+		if filter.allows(t.word) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// ruledTargetSet splits the target words and the output of a target rule into the two halves
+// combined by generateRuledCombinationsIter: the unique target words, and the unique ruled
+// words that are not target words already. Ruled words each get a group of their own.
+func ruledTargetSet(targets []targetWord, ruled []string) ([]targetWord, []targetWord) {
+	seen := make(map[string]bool, len(targets))
+	dict := make([]targetWord, 0, len(targets))
+	for _, t := range targets {
+		if !seen[t.word] {
+			seen[t.word] = true
+			dict = append(dict, t)
+		}
+	}
+	ruled = removeStringsPresentIn(removeDuplicates(ruled), wordsOf(dict))
+	return dict, newTargetWords(ruled, groupCount(dict))
+}
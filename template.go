@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateSlot is one piece of a template: fixed text, a choice from a list of words, or one
+// or more distinct target words
+type templateSlot struct {
+	literal  string
+	words    []string // choices of a wordlist or set slot
+	target   bool
+	category string // target slots only take targets of this category, empty takes any
+	count    int    // target words in a target slot, joined by the separator
+}
+
+// template describes the shape of candidates, such as {name}{sep}{year}{wl:symbols}
+type template struct {
+	pattern string
+	slots   []templateSlot
+}
+
+var templateRange = regexp.MustCompile(`^(\d+)-(\d+)$`)
+
+// parseTemplate parses a pattern into slots. Slots are written between braces:
+//
+//	{target} {target:2}    one or two distinct target words of any category
+//	{name} {date:2} ...    distinct target words of a category
+//	{wl} {wl:symbols}      a word of the wordlist arguments or a named --template-wordlist
+//	{a|b|c} {00-99}        one of a literal set of words or a zero-padded number range
+//	{year}                 a named --template-set
+//	{sep}                  the separator
+//
+// Everything else, including {{ and }} for braces, is literal text.
+func parseTemplate(pattern string, cli CLI, validWordlists []string) (*template, error) {
+	t := &template{pattern: pattern}
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			t.slots = append(t.slots, templateSlot{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if (c == '{' || c == '}') && i+1 < len(pattern) && pattern[i+1] == c {
+			literal.WriteByte(c)
+			i++
+			continue
+		}
+		if c == '}' {
+			return nil, fmt.Errorf("template %q: unexpected } at offset %d", pattern, i)
+		}
+		if c != '{' {
+			literal.WriteByte(c)
+			continue
+		}
+		end := strings.IndexByte(pattern[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unclosed { at offset %d", pattern, i)
+		}
+		spec := pattern[i+1 : i+end]
+		i += end
+
+		if spec == "sep" {
+			literal.WriteString(cli.Separator)
+			continue
+		}
+		slot, err := parseTemplateSlot(spec, cli, validWordlists)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", pattern, err)
+		}
+		flush()
+		t.slots = append(t.slots, slot)
+	}
+	flush()
+	return t, nil
+}
+
+func parseTemplateSlot(spec string, cli CLI, validWordlists []string) (templateSlot, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	switch {
+	case name == "target" || isTargetCategory(name):
+		slot := templateSlot{target: true, count: 1}
+		if name != "target" {
+			slot.category = name
+		}
+		if hasArg {
+			count, err := strconv.Atoi(arg)
+			if err != nil || count < 1 {
+				return slot, fmt.Errorf("invalid target count in {%s}", spec)
+			}
+			slot.count = count
+		}
+		return slot, nil
+
+	case name == "wl":
+		paths := validWordlists
+		if hasArg {
+			path, ok := cli.TemplateWordlist[arg]
+			if !ok {
+				return templateSlot{}, fmt.Errorf("no --template-wordlist named %q", arg)
+			}
+			paths = filterByValidWordlistTarget([]string{path}, cli)
+		}
+		var words []string
+		for _, path := range paths {
+			processed, err := loadWordlistCandidates(path, cli)
+			if err != nil {
+				return templateSlot{}, fmt.Errorf("reading wordlist %s: %w", path, err)
+			}
+			words = append(words, processed...)
+		}
+		return templateSlot{words: words}, nil
+	}
+
+	if set, ok := cli.TemplateSet[spec]; ok {
+		spec = set
+	}
+	if strings.Contains(spec, "|") {
+		return templateSlot{words: strings.Split(spec, "|")}, nil
+	}
+	if m := templateRange.FindStringSubmatch(spec); m != nil {
+		from, _ := strconv.Atoi(m[1])
+		to, _ := strconv.Atoi(m[2])
+		if from > to {
+			return templateSlot{}, fmt.Errorf("empty range {%s}", spec)
+		}
+		width := 0
+		if len(m[1]) > 1 && m[1][0] == '0' {
+			width = len(m[1])
+		}
+		var words []string
+		for n := from; n <= to; n++ {
+			words = append(words, fmt.Sprintf("%0*d", width, n))
+		}
+		return templateSlot{words: words}, nil
+	}
+	return templateSlot{}, fmt.Errorf("unknown slot {%s}", spec)
+}
+
+// targetPositions returns how many target words each target slot type needs, keyed by category
+// with "" for slots taking any category
+func (t *template) targetPositions() map[string]int {
+	positions := make(map[string]int)
+	for _, slot := range t.slots {
+		if slot.target {
+			positions[slot.category] += slot.count
+		}
+	}
+	return positions
+}
+
+// keyspace counts the candidates of the template that fit in bounds. Target words of one group
+// are never used twice in a candidate, which the wordlist and set slots do not care about.
+func (t *template) keyspace(targets []targetWord, bounds lengthBounds, sepLen int) uint64 {
+	limit := bounds.bucketLimit()
+	fixed := 0
+	counts := make([]uint64, limit+1)
+	counts[0] = 1
+	for _, slot := range t.slots {
+		switch {
+		case slot.target:
+			fixed += sepLen * (slot.count - 1)
+		case slot.words != nil:
+			choices := make([]uint64, limit+1)
+			for _, word := range slot.words {
+				choices[min(len(word), limit)]++
+			}
+			counts = convolveLengths(counts, choices, limit)
+		default:
+			fixed += len(slot.literal)
+		}
+	}
+	counts = convolveLengths(counts, templateTargetCounts(t.targetPositions(), targets, limit), limit)
+	return sumLengthCounts(counts, bounds, fixed)
+}
+
+// templateTargetCounts counts the ways to fill the target positions with words of distinct
+// groups, by summed length
+func templateTargetCounts(positions map[string]int, targets []targetWord, limit int) []uint64 {
+	// a state is the amount of positions filled per category, packed in mixed radix
+	var categories []string
+	var radix []int
+	states := 1
+	for _, category := range append([]string{""}, targetCategories...) {
+		if n := positions[category]; n > 0 {
+			categories = append(categories, category)
+			radix = append(radix, states)
+			states *= n + 1
+		}
+	}
+
+	table := make([][]uint64, states)
+	for s := range table {
+		table[s] = make([]uint64, limit+1)
+	}
+	table[0][0] = 1
+	for _, group := range groupTargetWords(targets) {
+		next := make([][]uint64, states)
+		for s := range table {
+			next[s] = append([]uint64{}, table[s]...)
+		}
+		for s := range table {
+			for c, category := range categories {
+				if category != "" && category != group[0].category {
+					continue
+				}
+				if (s/radix[c])%(positions[category]+1) == positions[category] {
+					continue
+				}
+				for _, t := range group {
+					for l := 0; l <= limit; l++ {
+						if n := table[s][l]; n != 0 {
+							next[s+radix[c]][min(l+len(t.word), limit)] += n
+						}
+					}
+				}
+			}
+		}
+		table = next
+	}
+
+	// the table counts sets per category, the positions of a category can be ordered freely
+	counts := table[states-1]
+	for _, category := range categories {
+		for i := 2; i <= positions[category]; i++ {
+			for l := range counts {
+				counts[l] *= uint64(i)
+			}
+		}
+	}
+	return counts
+}
+
+// convolveLengths adds up lengths of independent choices, capped at limit
+func convolveLengths(a, b []uint64, limit int) []uint64 {
+	result := make([]uint64, limit+1)
+	for l1, n1 := range a {
+		if n1 == 0 {
+			continue
+		}
+		for l2, n2 := range b {
+			if n2 != 0 {
+				result[min(l1+l2, limit)] += n1 * n2
+			}
+		}
+	}
+	return result
+}
+
+// generate writes every candidate of the template that fits in bounds, slot by slot. Every
+// word, literal and separator is a separate element for the join styles.
+func (t *template) generate(targets []targetWord, bounds lengthBounds, sep string, writer *candidateWriter) {
+	used := make([]bool, groupCount(targets))
+	parts := make([]string, 0, len(t.slots))
+	var fill func(slot, filled, size int)
+	fill = func(slot, filled, size int) {
+		if writer.done() || bounds.exceeded(size) {
+			return
+		}
+		if slot == len(t.slots) {
+			if bounds.allows(size) {
+				writer.writeJoined(parts, "")
+			}
+			return
+		}
+
+		s := t.slots[slot]
+		switch {
+		case s.target:
+			if filled == s.count {
+				fill(slot+1, 0, size)
+				return
+			}
+			if filled > 0 {
+				parts = append(parts, sep)
+				size += len(sep)
+			}
+			for _, target := range targets {
+				if used[target.group] || (s.category != "" && s.category != target.category) {
+					continue
+				}
+				used[target.group] = true
+				parts = append(parts, target.word)
+				fill(slot, filled+1, size+len(target.word))
+				parts = parts[:len(parts)-1]
+				used[target.group] = false
+			}
+			if filled > 0 {
+				parts = parts[:len(parts)-1]
+			}
+		case s.words != nil:
+			for _, word := range s.words {
+				parts = append(parts, word)
+				fill(slot+1, 0, size+len(word))
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, s.literal)
+			fill(slot+1, 0, size+len(s.literal))
+			parts = parts[:len(parts)-1]
+		}
+	}
+	fill(0, 0, 0)
+}
+
+// loadTemplates parses the --template patterns
+func loadTemplates(cli CLI) []*template {
+	validWordlists := filterByValidWordlistTarget(cli.Wordlists, cli)
+	var templates []*template
+	for _, pattern := range cli.Template {
+		t, err := parseTemplate(pattern, cli, validWordlists)
+		if err != nil {
+			failGeneration(err)
+		}
+		templates = append(templates, t)
+	}
+	return templates
+}
+
+// templatesKeyspace is calculateKeyspace for template mode
+func templatesKeyspace(targetFile []targetWord, cli CLI) uint64 {
+	bounds := newLengthBounds(cli)
+	var total uint64
+	for _, t := range loadTemplates(cli) {
+		keyspace := t.keyspace(targetFile, bounds, len(cli.Separator))
+		if cli.Debug {
+			log.Printf("Template %s has a keyspace of %d", t.pattern, keyspace)
+		}
+		total += keyspace
+	}
+	return total * uint64(len(cli.JoinStyle))
+}
+
+// processTemplates is processAllWordlists for template mode, templates run in the given order
+func processTemplates(targetFile []targetWord, cli CLI, writer *candidateWriter) {
+	bounds := newLengthBounds(cli)
+	for _, t := range loadTemplates(cli) {
+		if writer.done() {
+			return
+		}
+		if cli.Debug {
+			log.Printf("Processing template %s", t.pattern)
+		}
+		writer.stage.Template, writer.stage.start = t.pattern, writer.position
+		if writer.skipBlock(t.keyspace(targetFile, bounds, len(cli.Separator))) {
+			continue
+		}
+		t.generate(targetFile, bounds, cli.Separator, writer)
+	}
+}
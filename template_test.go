@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+const templateTargets = `targets:
+  - value: Robert
+    category: name
+    aliases: [Bob, Rob]
+  - value: Alice
+    category: name
+  - value: "1990"
+    category: date
+  - value: Paris
+    category: place
+  - Blue
+`
+
+func TestTemplateKeyspace(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"targets.yaml": templateTargets,
+		"wordlist.txt": "x\nyy\n",
+		"symbols.txt":  "!\n?!\n#\n",
+	})
+	base := []string{"targets.yaml", "wordlist.txt", "--template-set", "year=2000-2002", "--template-wordlist", "symbols=symbols.txt"}
+	for _, tt := range []struct {
+		name      string
+		templates []string
+		options   []string
+		want      int // candidates without length bounds
+	}{
+		// Robert, Bob, Rob, Alice, 1990, Paris and Blue
+		{"target", []string{"{target}"}, nil, 7},
+		// ordered pairs of words of distinct targets: 7*6 less the 3*2 pairs of Robert forms
+		{"two targets", []string{"{target:2}"}, nil, 36},
+		{"two targets and a separator", []string{"{target:2}"}, []string{"-s", "_"}, 36},
+		{"category", []string{"{name}{year}"}, nil, 4 * 3},
+		// Alice with one of the 3 Robert forms, in both orders
+		{"category twice", []string{"{name:2}"}, nil, 6},
+		{"categories", []string{"{name}{sep}{date}{wl:symbols}"}, []string{"-s", "."}, 4 * 1 * 3},
+		// Robert forms or Alice with any word of another target
+		{"category and any target", []string{"{name}{target}"}, nil, 3*4 + 1*6},
+		{"wordlist and set", []string{"{target}{wl}{!|@}"}, nil, 7 * 2 * 2},
+		{"padded range", []string{"{place}{00-12}"}, nil, 13},
+		{"literals", []string{"{{{date}}}-{name}"}, nil, 4},
+		{"missing category", []string{"{number}{wl}"}, nil, 0},
+		{"more slots than targets", []string{"{name:3}"}, nil, 0},
+		{"several templates", []string{"{name}{year}", "{target:2}"}, nil, 12 + 36},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := slices.Clone(base)
+			for _, template := range tt.templates {
+				args = append(args, "--template", template)
+			}
+			args = append(args, tt.options...)
+			all := lines(mustTarginator(t, dir, args...))
+			if len(all) != tt.want {
+				t.Fatalf("wrote %d candidates, want %d", len(all), tt.want)
+			}
+			if keyspace := keyspaceOfRun(t, dir, args...); keyspace != uint64(tt.want) {
+				t.Errorf("keyspace %d, want %d", keyspace, tt.want)
+			}
+
+			// the candidates of length bounds are those of the unbounded run that fit
+			for _, bounds := range [][2]int{{0, 6}, {8, 0}, {7, 10}, {9, 9}} {
+				var want []string
+				for _, candidate := range all {
+					if len(candidate) >= bounds[0] && (bounds[1] == 0 || len(candidate) <= bounds[1]) {
+						want = append(want, candidate)
+					}
+				}
+				bounded := append(slices.Clone(args), "--min-length", strconv.Itoa(bounds[0]), "--max-length", strconv.Itoa(bounds[1]))
+				if got := lines(mustTarginator(t, dir, bounded...)); !slices.Equal(got, want) {
+					t.Errorf("length %v: wrote %d candidates, want the %d of the unbounded run that fit", bounds, len(got), len(want))
+				}
+				if keyspace := keyspaceOfRun(t, dir, bounded...); keyspace != uint64(len(want)) {
+					t.Errorf("length %v: keyspace %d, want %d", bounds, keyspace, len(want))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// forms a multiword target can be expanded into
+const (
+	tokenTokens    = "tokens"
+	tokenInitials  = "initials"
+	tokenSquashed  = "squashed"
+	tokenFirstLast = "firstlast"
+)
+
+var tokenForms = []string{tokenTokens, tokenInitials, tokenSquashed, tokenFirstLast}
+
+// maxTokens is the most tokens a target can be split into, one mask bit per token
+const maxTokens = 64
+
+// splitTokens splits a multiword target such as John Michael Smith or Mary-Jane on spaces,
+// hyphens and underscores
+func splitTokens(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '_'
+	})
+}
+
+// joinTokens returns the whole-target forms of tokens enabled in forms: initials as written
+// and in lower and upper case (JMS, jms), squashed (JohnMichaelSmith) and first+last (JohnSmith)
+func joinTokens(tokens []string, forms map[string]bool) []string {
+	var result []string
+	if forms[tokenInitials] {
+		var initials strings.Builder
+		for _, token := range tokens {
+			r, _ := utf8.DecodeRuneInString(token)
+			initials.WriteRune(r)
+		}
+		result = append(result, initials.String(), strings.ToLower(initials.String()), strings.ToUpper(initials.String()))
+	}
+	if forms[tokenSquashed] {
+		result = append(result, strings.Join(tokens, ""))
+	}
+	if forms[tokenFirstLast] {
+		result = append(result, tokens[0]+tokens[len(tokens)-1])
+	}
+	return removeDuplicates(result)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WorkCLI holds the arguments of the work command
+type WorkCLI struct {
+	Coordinator string `arg:"" help:"URL of the coordinator, such as http://127.0.0.1:8090"`
+	Name        string `optional:"" help:"Name of the worker in the status of the coordinator, the hostname by default" default:""`
+	Exec        string `optional:"" help:"Feed the candidates to the stdin of this command, such as \"hashcat -m 0 hashes.txt\"" default:""`
+	OutputFile  string `optional:"" short:"o" help:"Output File" default:""`
+	Debug       bool   `optional:"" help:"Show Debug Messages" default:"false"`
+}
+
+// registration is the answer of the coordinator to POST /workers
+type registration struct {
+	ID        string   `json:"id"`
+	Job       []string `json:"job"`
+	Keyspace  uint64   `json:"keyspace"`
+	Heartbeat float64  `json:"heartbeat"` // seconds
+}
+
+// errUnknownWorker is returned when the coordinator forgot the worker, after a timeout or a restart
+var errUnknownWorker = errors.New("unknown worker")
+
+// coordinatorClient talks to the coordinator for a worker
+type coordinatorClient struct {
+	url  string
+	name string
+	mu   sync.Mutex
+	id   string
+}
+
+// post sends body as JSON to path and decodes the answer into out, when there is one
+func (c *coordinatorClient) post(path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(c.url+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errUnknownWorker
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s %s", path, resp.Status, bytes.TrimSpace(message))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retry calls f until it succeeds or fails for another reason than an unreachable coordinator
+func (c *coordinatorClient) retry(writer *candidateWriter, f func() error) error {
+	for {
+		err := f()
+		if err == nil || errors.Is(err, errUnknownWorker) || writer.interrupted.Load() {
+			return err
+		}
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) {
+			return err
+		}
+		log.Printf("Coordinator unreachable, retrying: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (c *coordinatorClient) register() (registration, error) {
+	var reg registration
+	if err := c.post("/workers", map[string]string{"name": c.name}, &reg); err != nil {
+		return reg, err
+	}
+	c.mu.Lock()
+	c.id = reg.ID
+	c.mu.Unlock()
+	return reg, nil
+}
+
+func (c *coordinatorClient) workerID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+// heartbeat keeps the worker known to the coordinator. A forgotten worker drops its chunk,
+// which the coordinator handed out again, and registers anew.
+func (c *coordinatorClient) heartbeat(writer *candidateWriter, interval time.Duration) {
+	for range time.Tick(max(interval, time.Second)) {
+		id := c.workerID()
+		err := c.post("/workers/"+id+"/heartbeat", struct{}{}, nil)
+		if errors.Is(err, errUnknownWorker) && id == c.workerID() {
+			log.Printf("Coordinator forgot worker %s, dropping its chunk", id)
+			writer.released.Store(true)
+		} else if err != nil {
+			log.Printf("Heartbeat: %v", err)
+		}
+	}
+}
+
+// runWork generates the chunks the coordinator hands out until the job is done
+func runWork(cmd WorkCLI) {
+	if cmd.Exec != "" && cmd.OutputFile != "" {
+		log.Fatal("Exec can not be used with an output file")
+	}
+	if cmd.Name == "" {
+		cmd.Name, _ = os.Hostname()
+	}
+	client := &coordinatorClient{url: strings.TrimSuffix(cmd.Coordinator, "/"), name: cmd.Name}
+	reg, err := client.register()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the worker reads the inputs of the job at the same paths as the coordinator
+	cli, err := parseJobArgs(reg.Job)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cli.Exec, cli.OutputFile, cli.Debug = cmd.Exec, cmd.OutputFile, cmd.Debug
+	g, err := prepareGeneration(cli)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyspace, err := g.keyspace()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keyspace != reg.Keyspace {
+		log.Fatalf("Keyspace is %d here and %d at the coordinator, the inputs of the job differ", keyspace, reg.Keyspace)
+	}
+	log.Printf("Worker %s (%s) working on %q", cmd.Name, reg.ID, reg.Job)
+
+	writer := newCandidateWriter(cli, g.policy, g.outputFilter, g.model)
+	defer writer.Flush()
+	handleSignals(writer)
+	go client.heartbeat(writer, time.Duration(reg.Heartbeat*float64(time.Second)))
+
+	for !writer.interrupted.Load() {
+		var answer struct {
+			Chunk *assignment `json:"chunk"`
+			Wait  float64     `json:"wait"`
+			Done  bool        `json:"done"`
+		}
+		err := client.retry(writer, func() error {
+			return client.post("/workers/"+client.workerID()+"/chunk", struct{}{}, &answer)
+		})
+		if errors.Is(err, errUnknownWorker) {
+			err = client.retry(writer, func() error {
+				again, err := client.register()
+				if err == nil && !slices.Equal(again.Job, reg.Job) {
+					return fmt.Errorf("the coordinator moved on to another job: %q", again.Job)
+				}
+				return err
+			})
+			if err == nil {
+				continue
+			}
+		}
+		if writer.interrupted.Load() {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if answer.Done {
+			log.Println("Job done")
+			break
+		}
+		if answer.Chunk == nil {
+			time.Sleep(time.Duration(answer.Wait * float64(time.Second)))
+			continue
+		}
+
+		chunk := answer.Chunk
+		if cli.Debug {
+			log.Printf("Chunk %d: candidates %d to %d", chunk.ID, chunk.Range.Start, chunk.Range.End)
+		}
+		g.cli.Skip, g.cli.Limit = chunk.Range.Start, chunk.Range.End-chunk.Range.Start
+		writer.skip, writer.limit, writer.position = g.cli.Skip, g.cli.Limit, 0
+		writer.stage, writer.lowPass = generationStage{Rule: -1}, false
+		writer.released.Store(false)
+		if writer.consumer != nil {
+			writer.consumer.resume(chunk.Range.Start)
+		}
+		if err := g.run(writer); err != nil {
+			log.Fatal(err)
+		}
+		writer.Flush()
+
+		if writer.released.Load() {
+			continue
+		}
+		if writer.consumer != nil {
+			// candidates still in the pipe are not done yet, the consumer may exit before reading them
+			writer.consumer.drain()
+		}
+		exitCode := 0
+		if writer.consumer.gone() {
+			exitCode = writer.consumer.close()
+		}
+		position := max(writer.deliveredPosition(), writer.skip)
+		err = client.retry(writer, func() error {
+			return client.post(fmt.Sprintf("/chunks/%d/finish", chunk.ID), map[string]any{"worker": chunk.Worker, "position": position}, nil)
+		})
+		if err != nil {
+			log.Printf("Reporting chunk %d: %v", chunk.ID, err)
+		}
+		if writer.consumer.gone() {
+			log.Printf("Consumer exited after candidate %d", position)
+			os.Exit(exitCode)
+		}
+		if writer.interrupted.Load() {
+			log.Printf("Stopped at candidate %d", position)
+			break
+		}
+	}
+
+	if writer.consumer != nil {
+		writer.Flush()
+		if exitCode := writer.consumer.close(); exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}
+	if writer.interrupted.Load() {
+		os.Exit(1)
+	}
+}